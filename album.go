@@ -0,0 +1,235 @@
+package gpm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/viperadnan-git/go-gpm/internal/core"
+)
+
+// Album batching defaults (see UploadOptions.AlbumBatchSize)
+const (
+	defaultAlbumBatchSize = 50
+	albumFlushInterval    = 10 * time.Second // Pending files are added at least this often
+	albumFlushTimeout     = time.Minute      // Limit for the final batch of a cancelled job
+)
+
+// albumItem is an uploaded file waiting to be added to the job's album
+type albumItem struct {
+	path     string
+	mediaKey string
+	dedupKey string
+}
+
+// albumBatcher adds a job's files to its album in small batches while the job runs,
+// so files uploaded before a crash or cancellation are already in the album. Batches are
+// added by the batcher's own goroutine, when full or at least every albumFlushInterval,
+// so upload workers never wait for album requests.
+type albumBatcher struct {
+	api       *core.Api
+	name      string
+	batchSize int
+
+	mu      sync.Mutex
+	pending []albumItem
+
+	full    chan struct{} // Wakes the flusher when a batch is full
+	stop    chan struct{}
+	stopped chan struct{}
+
+	// Only used by the flusher, and by the final flush once it has stopped
+	key       string
+	createErr error // Set when creating the album failed and it may exist anyway
+}
+
+// newAlbumBatcher returns a batcher for the album with the given key, or one that
+// creates an album with the given name; nil if neither is set. Its flusher runs until
+// finish is called or ctx is done, reporting outcomes through emit.
+func newAlbumBatcher(ctx context.Context, api *core.Api, key, name string, batchSize int, emit func(UploadEvent)) *albumBatcher {
+	if key == "" && name == "" {
+		return nil
+	}
+	b := &albumBatcher{
+		api:       api,
+		name:      name,
+		key:       key,
+		batchSize: workersOr(batchSize, defaultAlbumBatchSize),
+		full:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go b.run(ctx, emit)
+	return b
+}
+
+// add queues a file, waking the flusher when the batch is full
+func (b *albumBatcher) add(item albumItem) {
+	b.mu.Lock()
+	b.pending = append(b.pending, item)
+	full := len(b.pending) >= b.batchSize
+	b.mu.Unlock()
+	if full {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// remaining removes and returns the files not yet flushed
+func (b *albumBatcher) remaining() []albumItem {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	batch := b.pending
+	b.pending = nil
+	return batch
+}
+
+// run flushes pending files when a batch is full or the flush interval has passed
+func (b *albumBatcher) run(ctx context.Context, emit func(UploadEvent)) {
+	defer close(b.stopped)
+	timer := time.NewTimer(albumFlushInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-b.full:
+		case <-timer.C:
+		case <-b.stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+		b.flush(ctx, b.remaining(), emit)
+		timer.Reset(albumFlushInterval)
+	}
+}
+
+// finish stops the flusher and adds the remaining files
+func (b *albumBatcher) finish(ctx context.Context, emit func(UploadEvent)) {
+	close(b.stop)
+	<-b.stopped
+	b.flush(ctx, b.remaining(), emit)
+}
+
+// flush adds a batch to the album, creating the album with the first batch if only
+// a name was given, and emits one event per file with the outcome
+func (b *albumBatcher) flush(ctx context.Context, batch []albumItem, emit func(UploadEvent)) {
+	if len(batch) == 0 {
+		return
+	}
+
+	// Duplicates share a media key, add each item once
+	seen := make(map[string]bool, len(batch))
+	var mediaKeys []string
+	for _, item := range batch {
+		if !seen[item.mediaKey] {
+			seen[item.mediaKey] = true
+			mediaKeys = append(mediaKeys, item.mediaKey)
+		}
+	}
+
+	var err error
+	switch {
+	case b.createErr != nil:
+		err = b.createErr
+	case b.key == "":
+		var key string
+		key, err = b.api.CreateAlbum(ctx, b.name, mediaKeys)
+		if err == nil && key == "" {
+			err = fmt.Errorf("no album key returned")
+		}
+		b.key = key
+		if err != nil && albumMayExist(err) {
+			// Creating it again could leave two albums of the same name
+			b.createErr = fmt.Errorf("album %q may have been created by a failed request, not created again: %w", b.name, err)
+		}
+	default:
+		err = b.api.AddMediaToAlbum(ctx, b.key, mediaKeys)
+	}
+
+	for _, item := range batch {
+		event := UploadEvent{
+			Path: item.path, Status: StatusAlbumAdded, MediaKey: item.mediaKey, DedupKey: item.dedupKey,
+			AlbumKey: b.key, AlbumName: b.name,
+		}
+		if err != nil {
+			event.Status = StatusAlbumFailed
+			event.Error = &UploadError{Op: "album", Err: err}
+		}
+		emit(event)
+	}
+}
+
+// albumMayExist reports whether a failed CreateAlbum may have created the album anyway.
+// Only a request the server rejected, or a connection that was refused, certainly did not.
+func albumMayExist(err error) bool {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode >= 500
+	}
+	return !errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// jobAlbums holds the albums of a job: the one from its options and those of its items
+type jobAlbums struct {
+	ctx       context.Context
+	api       *core.Api
+	batchSize int
+	emit      func(UploadEvent)
+	job       *albumBatcher // nil without a job album
+
+	mu    sync.Mutex
+	items map[string]*albumBatcher // By album key or name
+}
+
+// newJobAlbums returns the albums of a job, whose batches are flushed while ctx is
+// alive and reported through emit
+func newJobAlbums(ctx context.Context, api *core.Api, opts UploadOptions, emit func(UploadEvent)) *jobAlbums {
+	return &jobAlbums{
+		ctx:       ctx,
+		api:       api,
+		batchSize: opts.AlbumBatchSize,
+		emit:      emit,
+		job:       newAlbumBatcher(ctx, api, opts.AlbumKey, opts.AlbumName, opts.AlbumBatchSize, emit),
+		items:     make(map[string]*albumBatcher),
+	}
+}
+
+// forItem returns the batcher for a file, nil if it goes to no album
+func (a *jobAlbums) forItem(item *UploadItem) *albumBatcher {
+	if item == nil || (item.AlbumKey == "" && item.AlbumName == "") {
+		return a.job
+	}
+	id := "key:" + item.AlbumKey
+	if item.AlbumKey == "" {
+		id = "name:" + item.AlbumName
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	b, ok := a.items[id]
+	if !ok {
+		b = newAlbumBatcher(a.ctx, a.api, item.AlbumKey, item.AlbumName, a.batchSize, a.emit)
+		a.items[id] = b
+	}
+	return b
+}
+
+// finish stops the flushers and adds the remaining files of every album
+func (a *jobAlbums) finish(ctx context.Context) {
+	a.mu.Lock()
+	batchers := make([]*albumBatcher, 0, len(a.items)+1)
+	if a.job != nil {
+		batchers = append(batchers, a.job)
+	}
+	for _, b := range a.items {
+		batchers = append(batchers, b)
+	}
+	a.mu.Unlock()
+	for _, b := range batchers {
+		b.finish(ctx, a.emit)
+	}
+}
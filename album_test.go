@@ -0,0 +1,60 @@
+package gpm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestAlbumMayExist(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&HTTPError{StatusCode: 400}, false},
+		{&HTTPError{StatusCode: 429}, false},
+		{&HTTPError{StatusCode: 503}, true},
+		{fmt.Errorf("dial: %w", syscall.ECONNREFUSED), false},
+		{fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{context.DeadlineExceeded, true},
+	}
+	for _, tt := range tests {
+		if got := albumMayExist(tt.err); got != tt.want {
+			t.Errorf("albumMayExist(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestAlbumBatcherFlushesFullBatches(t *testing.T) {
+	events := make(chan UploadEvent, 4)
+	b := newAlbumBatcher(context.Background(), nil, "", "Trip", 2, func(e UploadEvent) { events <- e })
+	// A failed creation is not retried, so no request is made
+	b.createErr = errors.New("may exist")
+
+	b.add(albumItem{path: "a", mediaKey: "ka"})
+	select {
+	case e := <-events:
+		t.Fatalf("flushed %s before the batch was full", e.Path)
+	case <-time.After(50 * time.Millisecond):
+	}
+	b.add(albumItem{path: "b", mediaKey: "kb"})
+	for _, want := range []string{"a", "b"} {
+		select {
+		case e := <-events:
+			if e.Path != want || e.Status != StatusAlbumFailed {
+				t.Fatalf("event %s %s, want %s %s", e.Path, e.Status, want, StatusAlbumFailed)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("full batch was not flushed without a new item")
+		}
+	}
+
+	b.add(albumItem{path: "c", mediaKey: "kc"})
+	b.finish(context.Background(), func(e UploadEvent) { events <- e })
+	if e := <-events; e.Path != "c" {
+		t.Fatalf("finish flushed %s, want c", e.Path)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"sync"
 
 	"github.com/pelletier/go-toml/v2"
+	gpm "github.com/viperadnan-git/go-gpm"
 )
 
 // CachedToken holds the cached access token and expiry
@@ -26,12 +27,40 @@ type AccountConfig struct {
 	UploadThreads int               `toml:"upload_threads"` // Number of upload threads
 	Proxy         string            `toml:"proxy"`          // Proxy URL
 	Albums        map[string]string `toml:"albums"`         // Album name -> album key mapping
+
+	DeviceProfile       string `toml:"device_profile,omitempty"`        // Device profile name (built-in or from [profiles])
+	StorageSaverProfile string `toml:"storage_saver_profile,omitempty"` // Profile used for storage-saver uploads
+	QuotaProfile        string `toml:"quota_profile,omitempty"`         // Profile used for uploads counting against quota
+}
+
+// DeviceProfileConfig holds a custom device profile (empty fields use the default profile)
+type DeviceProfileConfig struct {
+	Model             string `toml:"model"`
+	Make              string `toml:"make"`
+	AndroidAPIVersion int64  `toml:"android_api_version"`
+	AndroidVersion    string `toml:"android_version"`
+	BuildID           string `toml:"build_id"`
+	ClientVersionCode int64  `toml:"client_version_code"`
+	UserAgent         string `toml:"user_agent"` // Template: {client_version}, {android_version}, {language}, {model}, {build}
+}
+
+// TransformConfig holds a pre-upload transform, either built-in or an external command
+type TransformConfig struct {
+	Name          string   `toml:"name"`
+	Builtin       string   `toml:"builtin,omitempty"`        // Built-in transform: "downscale"
+	Extensions    []string `toml:"extensions,omitempty"`     // Command: matching extensions, e.g. [".jxl", ".psd"]
+	Command       []string `toml:"command,omitempty"`        // Command: program and arguments with {input}, {output}, {dir}, {name}, {ext}
+	OutputExt     string   `toml:"output_ext,omitempty"`     // Command: extension of {output}, e.g. ".jpg"
+	MaxMegapixels float64  `toml:"max_megapixels,omitempty"` // Downscale: size limit
+	Quality       int      `toml:"quality,omitempty"`        // Downscale: JPEG quality
 }
 
 // Config represents the TOML configuration
 type Config struct {
-	Selected string           `toml:"selected"` // Selected account email
-	Accounts []*AccountConfig `toml:"accounts"` // List of account configs (order preserved)
+	Selected   string                          `toml:"selected"`             // Selected account email
+	Accounts   []*AccountConfig                `toml:"accounts"`             // List of account configs (order preserved)
+	Profiles   map[string]*DeviceProfileConfig `toml:"profiles,omitempty"`   // Custom device profiles by name
+	Transforms []*TransformConfig              `toml:"transforms,omitempty"` // Pre-upload transforms, applied in order
 }
 
 // DefaultAccountConfig returns the default account configuration
@@ -281,6 +310,60 @@ func (c *ConfigTokenCache) Set(token string, expiry int64) {
 	c.manager.UpdateAccountToken(c.email, token, expiry)
 }
 
+// GetDeviceProfiles returns the custom device profiles defined in the config
+func (m *ConfigManager) GetDeviceProfiles() map[string]gpm.DeviceProfile {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	profiles := make(map[string]gpm.DeviceProfile, len(m.config.Profiles))
+	for name, p := range m.config.Profiles {
+		if p == nil {
+			continue
+		}
+		profiles[name] = gpm.DeviceProfile{
+			Model:             p.Model,
+			Make:              p.Make,
+			AndroidAPIVersion: p.AndroidAPIVersion,
+			AndroidVersion:    p.AndroidVersion,
+			BuildID:           p.BuildID,
+			ClientVersionCode: p.ClientVersionCode,
+			UserAgent:         p.UserAgent,
+		}
+	}
+	return profiles
+}
+
+// GetTransforms returns the pre-upload transforms defined in the config
+func (m *ConfigManager) GetTransforms() ([]gpm.Transform, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var transforms []gpm.Transform
+	for i, t := range m.config.Transforms {
+		if t == nil {
+			continue
+		}
+		switch t.Builtin {
+		case "":
+			if len(t.Command) == 0 || len(t.Extensions) == 0 {
+				return nil, fmt.Errorf("transform %d (%s): command and extensions are required", i+1, t.Name)
+			}
+			transforms = append(transforms, &gpm.CommandTransform{
+				TransformName: t.Name,
+				Extensions:    t.Extensions,
+				Command:       t.Command,
+				OutputExt:     t.OutputExt,
+			})
+		case "downscale":
+			if t.MaxMegapixels <= 0 {
+				return nil, fmt.Errorf("transform %d (%s): max_megapixels is required", i+1, t.Name)
+			}
+			transforms = append(transforms, &gpm.DownscaleTransform{MaxMegapixels: t.MaxMegapixels, Quality: t.Quality})
+		default:
+			return nil, fmt.Errorf("transform %d (%s): unknown builtin: %s", i+1, t.Name, t.Builtin)
+		}
+	}
+	return transforms, nil
+}
+
 // GetAlbumKey returns the album key for a given album name from the selected account
 func (m *ConfigManager) GetAlbumKey(name string) string {
 	m.mu.RLock()
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha1"
 	"fmt"
+	"io"
+	"os"
+	"time"
 
 	gpm "github.com/viperadnan-git/go-gpm"
 
@@ -10,19 +15,145 @@ import (
 )
 
 func downloadAction(ctx context.Context, cmd *cli.Command) error {
+	if cmd.String("output") == "-" {
+		logToStderr()
+	}
 	if err := loadConfig(); err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
-	input := cmd.StringArg("input")
 	urlOnly := cmd.Bool("url")
 	outputPath := cmd.String("output")
+	opts := gpm.DownloadOptions{
+		Workers:        int(cmd.Int("threads")),
+		OutputDir:      outputPath,
+		Verify:         cmd.Bool("verify"),
+		Version:        gpm.DownloadVersion(cmd.String("version")),
+		EditedSuffix:   cmd.String("edited-suffix"),
+		OutputTemplate: cmd.String("output-template"),
+		OnConflict:     gpm.ConflictPolicy(cmd.String("on-conflict")),
+		Sidecar:        gpm.SidecarFormat(cmd.String("sidecar")),
+	}
+	switch opts.Version {
+	case gpm.DownloadVersionOriginal, gpm.DownloadVersionEdited, gpm.DownloadVersionBoth:
+	default:
+		return fmt.Errorf("invalid --version %q (use original, edited or both)", opts.Version)
+	}
+	switch opts.OnConflict {
+	case gpm.ConflictSkip, gpm.ConflictOverwrite, gpm.ConflictRename, gpm.ConflictHashSuffix:
+	default:
+		return fmt.Errorf("invalid --on-conflict %q (use skip, overwrite, rename or hash-suffix)", opts.OnConflict)
+	}
+	switch opts.Sidecar {
+	case "", gpm.SidecarXMP, gpm.SidecarJSON:
+	default:
+		return fmt.Errorf("invalid --sidecar %q (use xmp or json)", opts.Sidecar)
+	}
+	if opts.OutputTemplate != "" {
+		if _, err := gpm.ParsePathTemplate(opts.OutputTemplate); err != nil {
+			return err
+		}
+	}
+
+	// Collect inputs from both command-line args and file
+	inputs := cmd.Args().Slice()
+	fromFile := cmd.String("from-file")
+	if fromFile != "" {
+		fileInputs, err := readLinesFromFile(fromFile)
+		if err != nil {
+			return err
+		}
+		inputs = append(inputs, fileInputs...)
+	}
+	if len(inputs) == 0 {
+		return fmt.Errorf("at least one item is required (provide via command-line or --from-file)")
+	}
 
 	apiClient, err := createAPIClient()
 	if err != nil {
 		return err
 	}
 
+	// "-o -" streams a single item to stdout
+	if outputPath == "-" && !urlOnly {
+		if len(inputs) != 1 {
+			return fmt.Errorf("-o - writes a single item to stdout, got %d items", len(inputs))
+		}
+		if opts.Version == gpm.DownloadVersionBoth || opts.OutputTemplate != "" || opts.Sidecar != "" {
+			return fmt.Errorf("--version both, --output-template and --sidecar cannot be used with -o -")
+		}
+		return downloadToStdout(ctx, apiClient, inputs[0], opts)
+	}
+
+	// A single item keeps -o as a file path, unless a template builds the path
+	if len(inputs) == 1 && fromFile == "" && (opts.OutputTemplate == "" || urlOnly) {
+		return downloadOne(ctx, apiClient, inputs[0], outputPath, urlOnly, opts)
+	}
+	if urlOnly {
+		for _, input := range inputs {
+			mediaKey, err := apiClient.ResolveMediaKey(ctx, input)
+			if err != nil {
+				return err
+			}
+			info, err := apiClient.GetDownloadInfo(ctx, mediaKey)
+			if err != nil {
+				return fmt.Errorf("failed to get download info for %s: %w", input, err)
+			}
+			if err := printDownloadURLs(info, opts); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var total, downloaded, skipped, failed, unverified int
+	var downloadedBytes int64
+	startTime := time.Now()
+	for event := range apiClient.DownloadItems(ctx, inputs, opts) {
+		if event.Total > 0 {
+			total = event.Total
+			logger.Info("starting download", "items", total, "threads", opts.Workers)
+		}
+		switch event.Status {
+		case gpm.DownloadStatusDownloading:
+			logger.Debug("downloading", "input", event.Input, "media_key", event.MediaKey, "size", event.Bytes)
+		case gpm.DownloadStatusCompleted:
+			downloaded++
+			downloadedBytes += event.Bytes
+			progress := fmt.Sprintf("[%d/%d]", downloaded+skipped+failed, total)
+			if opts.Verify && !event.Verified {
+				unverified++
+				logger.Warn(progress+" downloaded, not verified (edited version or reduced quality)", "input", event.Input, "path", event.Path)
+			} else {
+				logger.Info(progress+" downloaded", "input", event.Input, "path", event.Path, "verified", event.Verified)
+			}
+			if event.EditedPath != "" {
+				logger.Info(progress+" downloaded edited version", "input", event.Input, "path", event.EditedPath)
+			}
+		case gpm.DownloadStatusSkipped:
+			skipped++
+			progress := fmt.Sprintf("[%d/%d]", downloaded+skipped+failed, total)
+			logger.Info(progress+" skipped, file exists", "input", event.Input, "path", event.Path)
+		case gpm.DownloadStatusFailed:
+			failed++
+			progress := fmt.Sprintf("[%d/%d]", downloaded+skipped+failed, total)
+			logger.Error(progress+" failed", "input", event.Input, "path", event.Path, "error", event.Error)
+		}
+	}
+
+	summary := []any{"downloaded", downloaded, "skipped", skipped, "failed", failed, "bytes", downloadedBytes, "elapsed", time.Since(startTime).Round(time.Second)}
+	if opts.Verify {
+		summary = append(summary, "unverified", unverified)
+	}
+	logger.Info("download complete", summary...)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d items failed to download", failed, total)
+	}
+	return nil
+}
+
+// downloadOne downloads a single item, outputPath may be a file or directory
+func downloadOne(ctx context.Context, apiClient *gpm.GooglePhotosAPI, input, outputPath string, urlOnly bool, opts gpm.DownloadOptions) error {
 	mediaKey, err := apiClient.ResolveMediaKey(ctx, input)
 	if err != nil {
 		return err
@@ -39,21 +170,145 @@ func downloadAction(ctx context.Context, cmd *cli.Command) error {
 
 	// If --url flag is set, just print the URL and exit
 	if urlOnly {
-		fmt.Println(info.DownloadURL)
-		return nil
+		return printDownloadURLs(info, opts)
 	}
 
-	// Download the file
-	logger.Info("downloading", "filename", info.Filename, "size", info.FileSize, "is_edited", info.IsEdited)
-	savedPath, err := gpm.DownloadFile(info.DownloadURL, outputPath, info.Filename)
+	versions, err := gpm.DownloadVersions(info, opts.Version, opts.EditedSuffix)
 	if err != nil {
 		return err
 	}
-	logger.Info("download complete", "path", savedPath)
+	for i, version := range versions {
+		target := outputPath
+		if i > 0 {
+			target = editedOutputPath(outputPath, opts.EditedSuffix)
+		}
+		if err := downloadVersion(ctx, apiClient, mediaKey, version, target, opts); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// downloadToStdout streams one version of an item to stdout, verifying it afterwards if asked
+func downloadToStdout(ctx context.Context, apiClient *gpm.GooglePhotosAPI, input string, opts gpm.DownloadOptions) error {
+	mediaKey, err := apiClient.ResolveMediaKey(ctx, input)
+	if err != nil {
+		return err
+	}
+	info, err := apiClient.GetDownloadInfo(ctx, mediaKey)
+	if err != nil {
+		return fmt.Errorf("failed to get download info: %w", err)
+	}
+	versions, err := gpm.DownloadVersions(info, opts.Version, opts.EditedSuffix)
+	if err != nil {
+		return err
+	}
+	version := versions[0]
+	edited := gpm.IsEditedDownload(version)
+
+	logger.Info("streaming to stdout", "filename", version.Filename, "size", version.FileSize, "edited_version", edited)
+	body, err := apiClient.OpenDownloadInfo(ctx, version)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	hasher := sha1.New()
+	written, err := io.Copy(io.MultiWriter(os.Stdout, hasher), body)
+	if err != nil {
+		return fmt.Errorf("download interrupted after %d bytes: %w", written, err)
+	}
+	if !edited && version.FileSize > 0 && written != version.FileSize {
+		return fmt.Errorf("size mismatch: got %d bytes, expected %d", written, version.FileSize)
+	}
+	if opts.Verify {
+		verified, err := apiClient.VerifyDownload(ctx, mediaKey, version, hasher.Sum(nil), nil)
+		if err != nil {
+			return err
+		}
+		if verified {
+			logger.Info("download verified", "media_key", mediaKey)
+		} else {
+			logger.Warn("streamed " + unverifiableReason(version) + ", not verified")
+		}
+	}
+	logger.Info("download complete", "bytes", written)
+	return nil
+}
+
+// downloadVersion downloads and optionally verifies one version of an item
+func downloadVersion(ctx context.Context, apiClient *gpm.GooglePhotosAPI, mediaKey string, info *gpm.DownloadInfo, outputPath string, opts gpm.DownloadOptions) error {
+	edited := gpm.IsEditedDownload(info)
+	logger.Info("downloading", "filename", info.Filename, "size", info.FileSize, "is_edited", info.IsEdited, "edited_version", edited)
+	var saveOpts []gpm.DownloadOption
+	if opts.Verify {
+		// Checked before the download replaces an existing file
+		saveOpts = append(saveOpts, gpm.WithDownloadVerify())
+	}
+	saved, err := apiClient.SaveDownload(ctx, info, outputPath, opts.OnConflict, saveOpts...)
+	if err != nil {
+		return err
+	}
+
+	if saved.Skipped {
+		logger.Info("skipped, file exists", "path", saved.Path)
+	} else if opts.Verify {
+		if saved.Verified {
+			logger.Info("download verified", "media_key", mediaKey)
+		} else {
+			logger.Warn("downloaded "+unverifiableReason(info)+", not verified", "path", saved.Path)
+		}
+	}
+
+	if opts.Sidecar != "" {
+		sidecarPath, err := gpm.WriteSidecar(saved.Path, mediaKey, info, opts.Sidecar)
+		if err != nil {
+			return err
+		}
+		logger.Debug("wrote sidecar", "path", sidecarPath)
+	}
+	if !saved.Skipped {
+		logger.Info("download complete", "path", saved.Path)
+	}
+	return nil
+}
+
+// unverifiableReason describes why a download cannot be verified
+func unverifiableReason(info *gpm.DownloadInfo) string {
+	if gpm.IsEditedDownload(info) {
+		return "edited version"
+	}
+	return fmt.Sprintf("item stored at %d%% quality", info.QualityPercentage)
+}
+
+// printDownloadURLs prints the URLs of the versions selected by opts
+func printDownloadURLs(info *gpm.DownloadInfo, opts gpm.DownloadOptions) error {
+	versions, err := gpm.DownloadVersions(info, opts.Version, opts.EditedSuffix)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		fmt.Println(version.DownloadURL)
+	}
+	return nil
+}
+
+// editedOutputPath returns where a single item's edited copy goes: outputPath itself if it is
+// empty or a directory (the copy's filename has the suffix), otherwise outputPath with the suffix
+func editedOutputPath(outputPath, suffix string) string {
+	if outputPath == "" {
+		return outputPath
+	}
+	if info, err := os.Stat(outputPath); err == nil && info.IsDir() {
+		return outputPath
+	}
+	return gpm.AddFilenameSuffix(outputPath, cmp.Or(suffix, gpm.DefaultEditedSuffix))
+}
+
 func thumbnailAction(ctx context.Context, cmd *cli.Command) error {
+	if cmd.String("output") == "-" {
+		logToStderr()
+	}
 	if err := loadConfig(); err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
@@ -77,6 +332,20 @@ func thumbnailAction(ctx context.Context, cmd *cli.Command) error {
 
 	logger.Info("downloading thumbnail", "media_key", mediaKey)
 
+	if outputPath == "-" {
+		body, err := apiClient.GetThumbnail(ctx, mediaKey, width, height, forceJpeg, noOverlay)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		written, err := io.Copy(os.Stdout, body)
+		if err != nil {
+			return fmt.Errorf("failed to write thumbnail to stdout: %w", err)
+		}
+		logger.Info("thumbnail downloaded", "bytes", written)
+		return nil
+	}
+
 	savedPath, err := apiClient.DownloadThumbnail(ctx, mediaKey, width, height, forceJpeg, noOverlay, outputPath)
 	if err != nil {
 		return err
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	gpm "github.com/viperadnan-git/go-gpm"
+
+	"github.com/urfave/cli/v3"
+)
+
+func dupesAction(ctx context.Context, cmd *cli.Command) error {
+	dir := cmd.StringArg("dir")
+	if dir == "" {
+		return fmt.Errorf("dir is required")
+	}
+
+	// Groups go to stdout, logs to stderr
+	logToStderr()
+	files, err := scanFiles(dir, walkOptions(cmd), cmd.Bool("disable-filter"))
+	if err != nil {
+		return err
+	}
+
+	// Unreadable files are left out of the groups
+	groups, err := gpm.FindDuplicates(ctx, files, int(cmd.Int("threads")))
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	var skipped []error
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		skipped = joined.Unwrap()
+	} else if err != nil {
+		skipped = []error{err}
+	}
+	for _, err := range skipped {
+		logger.Warn("skipped unreadable file", "error", err)
+	}
+
+	var duplicates int
+	for _, group := range groups {
+		fmt.Printf("%s (%d bytes)\n", group.DedupKey, group.Size)
+		for _, path := range group.Paths {
+			fmt.Printf("  %s\n", path)
+		}
+		duplicates += len(group.Paths) - 1
+	}
+
+	logger.Info("scan complete", "files", len(files), "groups", len(groups), "duplicates", duplicates, "skipped", len(skipped))
+	return nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	gpm "github.com/viperadnan-git/go-gpm"
+)
+
+// uploadListColumns is the column order of CSV/TSV upload lists without a header row
+var uploadListColumns = []string{"path", "album", "caption", "datetime", "latitude", "longitude", "favourite"}
+
+// uploadListAliases maps alternative header names to columns
+var uploadListAliases = map[string]string{
+	"file":     "path",
+	"filename": "path",
+	"date":     "datetime",
+	"lat":      "latitude",
+	"lon":      "longitude",
+	"lng":      "longitude",
+	"favorite": "favourite",
+}
+
+// uploadListRecord is a line of a JSONL upload list
+type uploadListRecord struct {
+	Path      string   `json:"path"`
+	Album     string   `json:"album"`
+	Caption   string   `json:"caption"`
+	DateTime  string   `json:"datetime"`
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	Favourite bool     `json:"favourite"`
+}
+
+// uploadListDateLayouts are the accepted datetime formats; those without a zone use local time
+var uploadListDateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// readUploadList reads an upload list (.csv, .tsv/.txt or .jsonl) into upload items.
+// Relative paths are resolved against the list's directory. A path listed twice is an
+// error, as only one set of metadata can be applied to it.
+func readUploadList(path string) ([]gpm.UploadItem, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file list: %w", err)
+	}
+	defer file.Close()
+
+	var records []uploadListRecord
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		records, err = readDelimitedUploadList(file, ',')
+	case ".tsv", ".tab", ".txt":
+		records, err = readDelimitedUploadList(file, '\t')
+	case ".jsonl", ".ndjson":
+		records, err = readJSONLUploadList(file)
+	default:
+		return nil, fmt.Errorf("unsupported file list format: %s (use .csv, .tsv or .jsonl)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	items := make([]gpm.UploadItem, 0, len(records))
+	entries := make(map[string]int, len(records)) // Entry numbers by path
+	for i, record := range records {
+		item, err := record.item(dir)
+		if err != nil {
+			return nil, fmt.Errorf("%s: entry %d: %w", path, i+1, err)
+		}
+		if first, ok := entries[item.Path]; ok {
+			return nil, fmt.Errorf("%s: entry %d: %s is already listed in entry %d", path, i+1, item.Path, first)
+		}
+		entries[item.Path] = i + 1
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// readDelimitedUploadList reads CSV or TSV rows. A first row starting with a "path"
+// column (or an alias of it) is a header naming the columns, otherwise uploadListColumns is used.
+func readDelimitedUploadList(r io.Reader, comma rune) ([]uploadListRecord, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = comma
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = comma == '\t'
+
+	columns := uploadListColumns
+	var records []uploadListRecord
+	for first := true; ; first = false {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if first && uploadListColumn(row[0]) == "path" {
+			columns = make([]string, len(row))
+			for i, name := range row {
+				columns[i] = uploadListColumn(name)
+			}
+			continue
+		}
+
+		var record uploadListRecord
+		for i, value := range row {
+			if i >= len(columns) {
+				break
+			}
+			if err := record.set(columns[i], strings.TrimSpace(value)); err != nil {
+				line, _ := reader.FieldPos(i)
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		if record.Path != "" {
+			records = append(records, record)
+		}
+	}
+}
+
+// uploadListColumn normalizes a header name, resolving aliases
+func uploadListColumn(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := uploadListAliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+// readJSONLUploadList reads one JSON object per line
+func readJSONLUploadList(r io.Reader) ([]uploadListRecord, error) {
+	var records []uploadListRecord
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var record uploadListRecord
+		if err := json.Unmarshal([]byte(text), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if record.Path == "" {
+			return nil, fmt.Errorf("line %d: path is required", line)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// set assigns a CSV/TSV column value; unknown columns are ignored
+func (r *uploadListRecord) set(column, value string) error {
+	if value == "" {
+		return nil
+	}
+	switch column {
+	case "path":
+		r.Path = value
+	case "album":
+		r.Album = value
+	case "caption":
+		r.Caption = value
+	case "datetime":
+		r.DateTime = value
+	case "latitude", "longitude":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", column, value)
+		}
+		if column == "latitude" {
+			r.Latitude = &f
+		} else {
+			r.Longitude = &f
+		}
+	case "favourite":
+		switch strings.ToLower(value) {
+		case "yes", "y", "x":
+			r.Favourite = true
+		case "no", "n":
+		default:
+			favourite, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid favourite: %s", value)
+			}
+			r.Favourite = favourite
+		}
+	}
+	return nil
+}
+
+// item validates the record and converts it to an upload item
+func (r uploadListRecord) item(dir string) (gpm.UploadItem, error) {
+	item := gpm.UploadItem{
+		Path:      r.Path,
+		AlbumName: r.Album,
+		Caption:   r.Caption,
+		Favourite: r.Favourite,
+	}
+	if !gpm.IsURL(item.Path) {
+		if filepath.IsAbs(item.Path) {
+			item.Path = filepath.Clean(item.Path)
+		} else {
+			item.Path = filepath.Join(dir, item.Path)
+		}
+	}
+
+	if r.DateTime != "" {
+		var err error
+		for _, layout := range uploadListDateLayouts {
+			if item.DateTime, err = time.ParseInLocation(layout, r.DateTime, time.Local); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return item, fmt.Errorf("invalid datetime: %s (use RFC3339 or YYYY-MM-DD [HH:MM[:SS]])", r.DateTime)
+		}
+	}
+
+	if (r.Latitude == nil) != (r.Longitude == nil) {
+		return item, fmt.Errorf("latitude and longitude must be set together")
+	}
+	if r.Latitude != nil {
+		if *r.Latitude < -90 || *r.Latitude > 90 || *r.Longitude < -180 || *r.Longitude > 180 {
+			return item, fmt.Errorf("location out of range: %g, %g", *r.Latitude, *r.Longitude)
+		}
+		item.Location = &gpm.Location{Latitude: *r.Latitude, Longitude: *r.Longitude}
+	}
+	return item, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	gpm "github.com/viperadnan-git/go-gpm"
+)
+
+func TestReadUploadList(t *testing.T) {
+	dir, err := filepath.Abs(filepath.Join("testdata", "uploadlist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := func(layout, value string) time.Time {
+		parsed, err := time.ParseInLocation(layout, value, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	beach := filepath.Join(dir, "beach.jpg")
+	location := &gpm.Location{Latitude: 36.5, Longitude: -4.9}
+
+	tests := []struct {
+		file string
+		want []gpm.UploadItem
+	}{
+		{"header.csv", []gpm.UploadItem{
+			{Path: beach, AlbumName: "Spain", Caption: "Sunset, day 1", DateTime: local("2006-01-02 15:04", "2024-07-01 19:30"), Location: location, Favourite: true},
+			{Path: filepath.Join(dir, "city.jpg")},
+		}},
+		{"columns.tsv", []gpm.UploadItem{
+			{Path: beach, AlbumName: "Spain", Caption: "Sunset", DateTime: time.Date(2024, 7, 1, 19, 30, 0, 0, time.UTC)},
+			{Path: filepath.FromSlash("/photos/other.jpg")},
+		}},
+		{"list.jsonl", []gpm.UploadItem{
+			{Path: beach, AlbumName: "Spain", Caption: "Sunset", DateTime: local("2006-01-02", "2024-07-01"), Location: location, Favourite: true},
+			{Path: "https://example.com/city.jpg"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			items, err := readUploadList(filepath.Join(dir, tt.file))
+			if err != nil {
+				t.Fatalf("readUploadList: %v", err)
+			}
+			if len(items) != len(tt.want) {
+				t.Fatalf("read %d items, want %d: %+v", len(items), len(tt.want), items)
+			}
+			for i := range items {
+				if !items[i].DateTime.Equal(tt.want[i].DateTime) {
+					t.Errorf("item %d datetime = %v, want %v", i, items[i].DateTime, tt.want[i].DateTime)
+				}
+				items[i].DateTime, tt.want[i].DateTime = time.Time{}, time.Time{}
+				if !reflect.DeepEqual(items[i], tt.want[i]) {
+					t.Errorf("item %d = %+v, want %+v", i, items[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReadUploadListErrors(t *testing.T) {
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"duplicate.csv", "entry 2: " + filepath.Join("testdata", "uploadlist", "beach.jpg") + " is already listed in entry 1"},
+		{"bad_datetime.csv", "entry 1: invalid datetime: July 1st"},
+		{"half_location.jsonl", "latitude and longitude must be set together"},
+		{"bad_favourite.tsv", "line 2: invalid favourite: maybe"},
+		{"missing.csv", "failed to open file list"},
+		{"list.xml", "unsupported file list format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			_, err := readUploadList(filepath.Join("testdata", "uploadlist", tt.file))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("readUploadList error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
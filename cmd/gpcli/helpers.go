@@ -30,22 +30,23 @@ func createAPIClient() (*gpm.GooglePhotosAPI, error) {
 	email := getSelectedEmail()
 	account := cfgManager.GetSelectedAccount()
 
-	var proxy string
+	cfg := gpm.ApiConfig{
+		AuthData:       authData,
+		DeviceProfiles: cfgManager.GetDeviceProfiles(),
+	}
 	if account != nil {
-		proxy = account.Proxy
+		cfg.Proxy = account.Proxy
+		cfg.DeviceProfile = account.DeviceProfile
+		cfg.StorageSaverProfile = account.StorageSaverProfile
+		cfg.QuotaProfile = account.QuotaProfile
 	}
 
 	// Create token cache for persistent token storage
-	var tokenCache gpm.TokenCache
 	if email != "" && authOverride == "" {
-		tokenCache = NewConfigTokenCache(cfgManager, email)
+		cfg.TokenCache = NewConfigTokenCache(cfgManager, email)
 	}
 
-	return gpm.NewGooglePhotosAPI(gpm.ApiConfig{
-		AuthData:   authData,
-		Proxy:      proxy,
-		TokenCache: tokenCache,
-	})
+	return gpm.NewGooglePhotosAPI(cfg)
 }
 
 // getAuthData returns the auth data string based on authOverride or selected config
@@ -123,3 +124,11 @@ func readLinesFromFile(filepath string) ([]string, error) {
 
 	return lines, nil
 }
+
+// errorString returns err.Error() or an empty string for nil errors
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -13,6 +13,7 @@ import (
 var logger *slog.Logger
 var currentLogLevel slog.Level
 var logFormat string
+var logOutput io.Writer = os.Stdout
 
 // humanHandler is a slog.Handler that outputs human-readable logs without timestamps
 type humanHandler struct {
@@ -64,11 +65,11 @@ func initLogger(level slog.Level) {
 	var handler slog.Handler
 	switch logFormat {
 	case "slog":
-		handler = slog.NewTextHandler(os.Stdout, opts)
+		handler = slog.NewTextHandler(logOutput, opts)
 	case "json":
-		handler = slog.NewJSONHandler(os.Stdout, opts)
+		handler = slog.NewJSONHandler(logOutput, opts)
 	default: // "human"
-		handler = &humanHandler{out: os.Stdout, level: level}
+		handler = &humanHandler{out: logOutput, level: level}
 	}
 	logger = slog.New(handler)
 	slog.SetDefault(logger)
@@ -79,3 +80,9 @@ func initQuietLogger() {
 	currentLogLevel = slog.LevelError
 	initLogger(slog.LevelError)
 }
+
+// logToStderr moves log output to stderr, keeping stdout for machine-readable data
+func logToStderr() {
+	logOutput = os.Stderr
+	initLogger(currentLogLevel)
+}
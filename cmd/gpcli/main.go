@@ -4,6 +4,7 @@ import (
 	"context"
 	"log/slog"
 	"os"
+	"time"
 
 	gpm "github.com/viperadnan-git/go-gpm"
 
@@ -126,12 +127,13 @@ func main() {
 				},
 			},
 			{
-				Name:  "upload",
-				Usage: "Upload a file or directory to Google Photos",
+				Name:      "upload",
+				Usage:     "Upload a file, directory or http(s) URL to Google Photos",
+				UsageText: "gpcli upload <filepath|url> | gpcli upload --retry-failed FILE",
 				Arguments: []cli.Argument{
 					&cli.StringArg{
 						Name:      "filepath",
-						UsageText: "<filepath>",
+						UsageText: "<filepath|url>",
 					},
 				},
 				Flags: []cli.Flag{
@@ -140,21 +142,88 @@ func main() {
 						Aliases: []string{"r"},
 						Usage:   "Include subdirectories",
 					},
+					&cli.BoolFlag{
+						Name:  "follow-symlinks",
+						Usage: "Descend into symlinked directories (symlink cycles are detected)",
+					},
+					&cli.BoolFlag{
+						Name:  "skip-hidden",
+						Usage: "Skip files and directories whose name starts with a dot",
+					},
+					&cli.BoolFlag{
+						Name:  "one-file-system",
+						Usage: "Do not descend into directories on other file systems",
+					},
+					&cli.StringFlag{
+						Name:  "from-file",
+						Usage: "Upload the files listed in a .csv, .tsv or .jsonl file with per-file columns: path, album, caption, datetime, latitude, longitude, favourite",
+					},
+					&cli.StringFlag{
+						Name:  "strip-metadata",
+						Usage: "Remove metadata from JPEG, HEIC and PNG copies before upload: gps, serial, all (comma-separated)",
+					},
+					&cli.BoolFlag{
+						Name:  "no-transforms",
+						Usage: "Skip the pre-upload transforms configured in the config file",
+					},
+					&cli.StringFlag{
+						Name:  "report",
+						Usage: "Write a run report to `FILE` (format from extension: .html, .csv or .json; CSV totals go to a .summary.csv file next to it)",
+					},
+					&cli.StringFlag{
+						Name:  "output",
+						Value: "text",
+						Usage: "Output format: text (logs), jsonl (one object per event plus a summary) or csv (path, media key and dedup key per file); logs go to stderr for jsonl/csv",
+					},
 					&cli.IntFlag{
 						Name:    "threads",
 						Aliases: []string{"t"},
 						Value:   3,
 						Usage:   "Number of upload threads",
 					},
+					&cli.IntFlag{
+						Name:  "hash-threads",
+						Value: 2,
+						Usage: "Number of hashing threads (reading files from disk)",
+					},
+					&cli.IntFlag{
+						Name:        "check-threads",
+						Usage:       "Number of threads checking whether files are already in the library",
+						DefaultText: "same as --threads",
+					},
+					&cli.IntFlag{
+						Name:        "commit-threads",
+						Usage:       "Number of threads committing uploads",
+						DefaultText: "same as --threads",
+					},
 					&cli.BoolFlag{
 						Name:    "force",
 						Aliases: []string{"f"},
 						Usage:   "Force upload even if file exists",
 					},
-					&cli.BoolFlag{
+					&cli.GenericFlag{
 						Name:    "delete",
 						Aliases: []string{"d"},
-						Usage:   "Delete from host after upload",
+						Usage:   "Delete from host after upload: --delete (always) or --delete=verify (only after server verification)",
+						Value:   &deleteModeValue{},
+					},
+					&cli.StringFlag{
+						Name:   "move-to",
+						Usage:  "Move uploaded files into this directory (preserving the relative tree) instead of deleting them",
+						Config: cli.StringConfig{TrimSpace: true},
+					},
+					&cli.DurationFlag{
+						Name:  "delete-after",
+						Usage: "Only delete/move files that have been in the library for at least this long (e.g. 720h)",
+					},
+					&cli.BoolFlag{
+						Name:  "delete-transformed",
+						Usage: "Also delete/move files that were uploaded as a transformed copy (--strip-metadata or config transforms); they are kept by default",
+					},
+					&cli.StringFlag{
+						Name:   "deletion-report",
+						Usage:  "Append every deleted or moved file with its media key to this file (JSONL)",
+						Config: cli.StringConfig{TrimSpace: true},
 					},
 					&cli.BoolFlag{
 						Name:  "disable-filter",
@@ -200,29 +269,128 @@ func main() {
 						Aliases: []string{"c"},
 						Usage:   "Dry run: check which files would be uploaded vs already exist",
 					},
+					&cli.BoolFlag{
+						Name:  "verify",
+						Usage: "Verify size, filename, hash and quality of each uploaded item on the server",
+					},
+					&cli.IntFlag{
+						Name:  "retries",
+						Value: 2,
+						Usage: "Retry a file this many times on transient (network/server) errors",
+					},
+					&cli.DurationFlag{
+						Name:  "retry-backoff",
+						Value: 5 * time.Second,
+						Usage: "Initial delay between retries (doubles on each attempt)",
+					},
+					&cli.StringFlag{
+						Name:   "failed-manifest",
+						Usage:  "Write failed files with their errors to this file (JSONL) for --retry-failed (default: failed.jsonl next to the config file)",
+						Config: cli.StringConfig{TrimSpace: true},
+					},
+					&cli.StringFlag{
+						Name:   "retry-failed",
+						Usage:  "Upload only the files listed in a failed manifest from a previous run",
+						Config: cli.StringConfig{TrimSpace: true},
+					},
 				},
 				Action: uploadAction,
 			},
 			{
-				Name:  "download",
-				Usage: "Download a media item",
+				Name:        "dupes",
+				Usage:       "Find files with identical content in a directory",
+				Description: "Groups local files by SHA1 without contacting the server. Upload uploads one file of each group and reports the others as duplicates.",
 				Arguments: []cli.Argument{
 					&cli.StringArg{
-						Name:      "input",
-						UsageText: "<item-key|filepath>",
+						Name:      "dir",
+						UsageText: "<dir>",
 					},
 				},
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "recursive",
+						Aliases: []string{"r"},
+						Usage:   "Include subdirectories",
+					},
+					&cli.BoolFlag{
+						Name:  "follow-symlinks",
+						Usage: "Descend into symlinked directories (symlink cycles are detected)",
+					},
+					&cli.BoolFlag{
+						Name:  "skip-hidden",
+						Usage: "Skip files and directories whose name starts with a dot",
+					},
+					&cli.BoolFlag{
+						Name:  "one-file-system",
+						Usage: "Do not descend into directories on other file systems",
+					},
+					&cli.BoolFlag{
+						Name:  "disable-filter",
+						Usage: "Disable file type filtering",
+					},
+					&cli.IntFlag{
+						Name:    "threads",
+						Aliases: []string{"t"},
+						Value:   3,
+						Usage:   "Number of hashing threads",
+					},
+				},
+				Action: dupesAction,
+			},
+			{
+				Name:      "download",
+				Usage:     "Download media items",
+				UsageText: "gpcli download <item-key|filepath> [input...] [--from-file FILE]",
 				Flags: []cli.Flag{
 					&cli.BoolFlag{
 						Name:  "url",
-						Usage: "Only print download URL without downloading",
+						Usage: "Only print download URLs without downloading",
 					},
 					&cli.StringFlag{
 						Name:    "output",
 						Aliases: []string{"o"},
-						Usage:   "Output path (file path or directory)",
+						Usage:   "Output path (file path or directory for a single item, directory for several, - for stdout)",
 						Config:  cli.StringConfig{TrimSpace: true},
 					},
+					&cli.StringFlag{
+						Name:    "from-file",
+						Aliases: []string{"i"},
+						Usage:   "Read item keys or file paths from file (one per line)",
+						Config:  cli.StringConfig{TrimSpace: true},
+					},
+					&cli.IntFlag{
+						Name:    "threads",
+						Aliases: []string{"t"},
+						Value:   3,
+						Usage:   "Number of concurrent downloads",
+					},
+					&cli.BoolFlag{
+						Name:  "verify",
+						Usage: "Check downloads against the item's dedup key before saving them, discarding mismatches (edited and reduced-quality items are not checked)",
+					},
+					&cli.StringFlag{
+						Name:  "version",
+						Value: "original",
+						Usage: "Version of edited items to download: original, edited or both",
+					},
+					&cli.StringFlag{
+						Name:  "edited-suffix",
+						Value: gpm.DefaultEditedSuffix,
+						Usage: "Filename suffix for edited copies saved with --version both",
+					},
+					&cli.StringFlag{
+						Name:  "output-template",
+						Usage: "Path template below --output, e.g. '{created:2006}/{created:01}/{filename}' (placeholders: filename, name, ext, created, uploaded, media_type, media_key, dedup_key)",
+					},
+					&cli.StringFlag{
+						Name:  "on-conflict",
+						Value: "overwrite",
+						Usage: "When a file exists: skip, overwrite, rename or hash-suffix",
+					},
+					&cli.StringFlag{
+						Name:  "sidecar",
+						Usage: "Write a metadata sidecar next to each file: xmp or json (Takeout layout)",
+					},
 				},
 				Action: downloadAction,
 			},
@@ -239,7 +407,7 @@ func main() {
 					&cli.StringFlag{
 						Name:    "output",
 						Aliases: []string{"o"},
-						Usage:   "Output path (file path or directory)",
+						Usage:   "Output path (file path or directory, - for stdout)",
 						Config:  cli.StringConfig{TrimSpace: true},
 					},
 					&cli.IntFlag{
@@ -542,7 +710,7 @@ func main() {
 		},
 	}
 
-	if err := cmd.Run(context.Background(), os.Args); err != nil {
+	if err := cmd.Run(handleSignals(context.Background()), os.Args); err != nil {
 		slog.Error("command failed", "error", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// failedManifestName is the failed-files manifest written next to the config file
+// when --failed-manifest is not set
+const failedManifestName = "failed.jsonl"
+
+// failedEntry is a single line of the failed-files manifest
+type failedEntry struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// defaultFailedManifest returns the manifest path used when --failed-manifest is not set
+func defaultFailedManifest() string {
+	return filepath.Join(filepath.Dir(cfgManager.GetConfigPath()), failedManifestName)
+}
+
+// samePath reports whether two paths name the same file location
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// writeFailedManifest writes failed files to path as JSONL (one object per line)
+func writeFailedManifest(path string, entries []failedEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create manifest directory: %w", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create manifest: %w", err)
+	}
+	defer file.Close()
+
+	enc := json.NewEncoder(file)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return fmt.Errorf("failed to write manifest: %w", err)
+		}
+	}
+	return nil
+}
+
+// readFailedManifest reads file paths from a failed-files manifest
+// Accepts JSONL written by writeFailedManifest or a plain list of paths (one per line)
+func readFailedManifest(path string) ([]string, error) {
+	lines, err := readLinesFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(lines))
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "{") {
+			paths = append(paths, line)
+			continue
+		}
+		var entry failedEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("invalid manifest entry %d: %w", i+1, err)
+		}
+		if entry.Path != "" {
+			paths = append(paths, entry.Path)
+		}
+	}
+	return paths, nil
+}
+
+// deletionEntry is a single line of the deletion report
+type deletionEntry struct {
+	Path     string `json:"path"`
+	MediaKey string `json:"media_key"`
+	Action   string `json:"action"` // "deleted" or "moved"
+	MovedTo  string `json:"moved_to,omitempty"`
+}
+
+// deletionReport appends removed files to a JSONL file as they happen,
+// so the report is complete even if the run is interrupted
+type deletionReport struct {
+	file *os.File
+	enc  *json.Encoder
+}
+
+// openDeletionReport creates (or appends to) the deletion report at path
+func openDeletionReport(path string) (*deletionReport, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open deletion report: %w", err)
+	}
+	return &deletionReport{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// Add records a removed file
+func (r *deletionReport) Add(entry deletionEntry) error {
+	return r.enc.Encode(entry)
+}
+
+// Close closes the report file
+func (r *deletionReport) Close() error {
+	return r.file.Close()
+}
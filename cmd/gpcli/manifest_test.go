@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFailedManifestRoundTrip(t *testing.T) {
+	// The manifest directory is created, as the default sits next to a config that may not exist yet
+	path := filepath.Join(t.TempDir(), "gpcli", failedManifestName)
+	entries := []failedEntry{
+		{Path: "/photos/a.jpg", Error: "commit error: request failed with status 500: "},
+		{Path: "/photos/b c.jpg", Error: "not processed: upload interrupted"},
+	}
+	if err := writeFailedManifest(path, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readFailedManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/photos/a.jpg", "/photos/b c.jpg"}; !slices.Equal(got, want) {
+		t.Fatalf("readFailedManifest() = %q, want %q", got, want)
+	}
+}
+
+func TestReadFailedManifest(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		wantErr bool
+	}{
+		{name: "plain list", content: "# retry\n/photos/a.jpg\n\n/photos/b.jpg\n", want: []string{"/photos/a.jpg", "/photos/b.jpg"}},
+		{name: "mixed", content: "{\"path\":\"/photos/a.jpg\",\"error\":\"x\"}\n/photos/b.jpg\n", want: []string{"/photos/a.jpg", "/photos/b.jpg"}},
+		{name: "entry without path", content: "{\"error\":\"x\"}\n", want: []string{}},
+		{name: "invalid entry", content: "{\"path\":\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "failed.jsonl")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readFailedManifest(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readFailedManifest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Fatalf("readFailedManifest() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultFailedManifest(t *testing.T) {
+	dir := t.TempDir()
+	saved := cfgManager
+	defer func() { cfgManager = saved }()
+	var err error
+	cfgManager, err = NewConfigManager(filepath.Join(dir, "gpcli.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := defaultFailedManifest()
+	if want := filepath.Join(dir, failedManifestName); path != want {
+		t.Fatalf("defaultFailedManifest() = %q, want %q", path, want)
+	}
+	t.Chdir(dir)
+	if !samePath(failedManifestName, path) {
+		t.Fatalf("samePath(%q, %q) = false, want true", failedManifestName, path)
+	}
+}
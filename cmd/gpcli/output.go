@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	gpm "github.com/viperadnan-git/go-gpm"
+)
+
+// outputVersion is the schema version of machine-readable upload output
+const outputVersion = 1
+
+// eventRecord is the JSONL representation of an upload event
+type eventRecord struct {
+	Version          int    `json:"version"`
+	Type             string `json:"type"` // "event"
+	Path             string `json:"path,omitempty"`
+	Status           string `json:"status,omitempty"`
+	MediaKey         string `json:"media_key,omitempty"`
+	DedupKey         string `json:"dedup_key,omitempty"`
+	Error            string `json:"error,omitempty"`
+	ErrorCode        string `json:"error_code,omitempty"`
+	Bytes            int64  `json:"bytes,omitempty"`
+	DurationMs       int64  `json:"duration_ms,omitempty"`
+	Worker           int    `json:"worker"`
+	Attempt          int    `json:"attempt,omitempty"`
+	Total            int    `json:"total,omitempty"`
+	Removed          bool   `json:"removed,omitempty"`
+	MovedTo          string `json:"moved_to,omitempty"`
+	CanonicalPath    string `json:"canonical_path,omitempty"`
+	TransformedPath  string `json:"transformed_path,omitempty"`
+	OriginalDedupKey string `json:"original_dedup_key,omitempty"`
+	AlbumKey         string `json:"album_key,omitempty"`
+}
+
+// summaryRecord is the final JSONL object of an upload run
+type summaryRecord struct {
+	Version    int    `json:"version"`
+	Type       string `json:"type"` // "summary"
+	Total      int    `json:"total"`
+	Uploaded   int    `json:"uploaded"`
+	Skipped    int    `json:"skipped"`
+	Duplicates int    `json:"duplicates"`
+	Failed     int    `json:"failed"`
+	Bytes      int64  `json:"bytes"`
+	DurationMs int64  `json:"duration_ms"`
+
+	MetadataFailed int      `json:"metadata_failed,omitempty"` // Uploaded or skipped files whose metadata was not set
+	Interrupted    bool     `json:"interrupted,omitempty"`
+	NotProcessed   []string `json:"not_processed,omitempty"` // Files dropped when the run was interrupted
+}
+
+// uploadSummary holds the totals of an upload run
+type uploadSummary struct {
+	Total, Uploaded, Skipped, Duplicates, Failed int
+	MetadataFailed                               int
+	Bytes                                        int64
+	Elapsed                                      time.Duration
+	Interrupted                                  bool
+	NotProcessed                                 []string
+}
+
+// eventWriter writes upload events in a machine-readable format
+type eventWriter interface {
+	Event(event gpm.UploadEvent) error
+	Summary(summary uploadSummary) error
+}
+
+// newEventWriter returns a writer for the given --output format, or nil for "text"
+func newEventWriter(format string, w io.Writer) (eventWriter, error) {
+	switch format {
+	case "", "text":
+		return nil, nil
+	case "jsonl":
+		return &jsonlWriter{enc: json.NewEncoder(w)}, nil
+	case "csv":
+		return newCSVWriter(w)
+	default:
+		return nil, fmt.Errorf("invalid output format: %s (use 'text', 'jsonl' or 'csv')", format)
+	}
+}
+
+// jsonlWriter emits one versioned object per event, then a summary object
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func (w *jsonlWriter) Event(event gpm.UploadEvent) error {
+	record := eventRecord{
+		Version:          outputVersion,
+		Type:             "event",
+		Path:             event.Path,
+		Status:           string(event.Status),
+		MediaKey:         event.MediaKey,
+		DedupKey:         event.DedupKey,
+		ErrorCode:        gpm.ErrorCode(event.Error),
+		Bytes:            event.Bytes,
+		DurationMs:       event.Duration.Milliseconds(),
+		Worker:           event.WorkerID,
+		Attempt:          event.Attempt,
+		Total:            event.Total,
+		Removed:          event.Removed,
+		MovedTo:          event.MovedTo,
+		CanonicalPath:    event.CanonicalPath,
+		TransformedPath:  event.TransformedPath,
+		OriginalDedupKey: event.OriginalDedupKey,
+		AlbumKey:         event.AlbumKey,
+	}
+	if event.Error != nil {
+		record.Error = event.Error.Error()
+	}
+	return w.enc.Encode(record)
+}
+
+func (w *jsonlWriter) Summary(summary uploadSummary) error {
+	return w.enc.Encode(summaryRecord{
+		Version:    outputVersion,
+		Type:       "summary",
+		Total:      summary.Total,
+		Uploaded:   summary.Uploaded,
+		Skipped:    summary.Skipped,
+		Duplicates: summary.Duplicates,
+		Failed:     summary.Failed,
+		Bytes:      summary.Bytes,
+		DurationMs: summary.Elapsed.Milliseconds(),
+
+		MetadataFailed: summary.MetadataFailed,
+		Interrupted:    summary.Interrupted,
+		NotProcessed:   summary.NotProcessed,
+	})
+}
+
+// csvWriter emits one row per finished file mapping path to media key and dedup key
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(w io.Writer) (*csvWriter, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"path", "status", "media_key", "dedup_key", "error_code"}); err != nil {
+		return nil, err
+	}
+	cw.Flush()
+	return &csvWriter{w: cw}, cw.Error()
+}
+
+func (w *csvWriter) Event(event gpm.UploadEvent) error {
+	switch event.Status {
+	case gpm.StatusCompleted, gpm.StatusSkipped, gpm.StatusDuplicate, gpm.StatusFailed, gpm.StatusVerifyFailed, gpm.StatusCanceled:
+	default:
+		return nil
+	}
+	w.w.Write([]string{event.Path, string(event.Status), event.MediaKey, event.DedupKey, gpm.ErrorCode(event.Error)})
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func (w *csvWriter) Summary(uploadSummary) error {
+	return nil
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	gpm "github.com/viperadnan-git/go-gpm"
+)
+
+// reportThumbnailWidth is the thumbnail width linked from report rows
+const reportThumbnailWidth = 256
+
+// reportFile is a per-file row of the run report
+type reportFile struct {
+	Path             string `json:"path"`
+	Status           string `json:"status"`
+	MediaKey         string `json:"media_key,omitempty"`
+	DedupKey         string `json:"dedup_key,omitempty"`
+	OriginalDedupKey string `json:"original_dedup_key,omitempty"` // Before transforms, e.g. metadata scrubbing
+	ThumbnailURL     string `json:"thumbnail_url,omitempty"`
+	Album            string `json:"album,omitempty"`
+	AlbumError       string `json:"album_error,omitempty"`
+	MetadataError    string `json:"metadata_error,omitempty"`
+	CanonicalPath    string `json:"canonical_path,omitempty"`
+	Bytes            int64  `json:"bytes,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
+
+// reportAlbum is the album files were added to during the run
+type reportAlbum struct {
+	Name   string   `json:"name"`
+	Key    string   `json:"key,omitempty"`
+	Items  int      `json:"items"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// runReport collects upload events into a report written at the end of the run
+type runReport struct {
+	path      string
+	thumbnail func(mediaKey string) string
+	start     time.Time
+	end       time.Time
+	files     []*reportFile
+	byKey     map[string][]*reportFile // Rows by media key, for album assignments
+	counts    map[gpm.UploadStatus]int
+	bytes     int64
+	albums    []*reportAlbum
+}
+
+// reportData is the serialized form of a run report
+type reportData struct {
+	Version        int            `json:"version"`
+	StartedAt      time.Time      `json:"started_at"`
+	FinishedAt     time.Time      `json:"finished_at"`
+	ElapsedSeconds float64        `json:"elapsed_seconds"`
+	Totals         map[string]int `json:"totals"`
+	Bytes          int64          `json:"bytes"`
+	BytesPerSecond float64        `json:"bytes_per_second"`
+	FilesPerSecond float64        `json:"files_per_second"`
+	Albums         []*reportAlbum `json:"albums,omitempty"`
+	Failed         []*reportFile  `json:"failed,omitempty"`
+	Files          []*reportFile  `json:"files"`
+}
+
+// newRunReport validates the report path and starts a report.
+// The format is taken from the extension: .html, .csv or .json.
+func newRunReport(path string, thumbnail func(mediaKey string) string) (*runReport, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm", ".csv", ".json":
+	default:
+		return nil, fmt.Errorf("unsupported report format: %s (use .html, .csv or .json)", path)
+	}
+	return &runReport{
+		path:      path,
+		thumbnail: thumbnail,
+		start:     time.Now(),
+		byKey:     make(map[string][]*reportFile),
+		counts:    make(map[gpm.UploadStatus]int),
+	}, nil
+}
+
+// Event records the final state of each file
+func (r *runReport) Event(event gpm.UploadEvent) {
+	switch event.Status {
+	case gpm.StatusMetadataFailed:
+		// Follows the file's final event, so its row already exists
+		r.counts[event.Status]++
+		for _, row := range r.byKey[event.MediaKey] {
+			if row.Path == event.Path {
+				row.MetadataError = errorString(event.Error)
+			}
+		}
+		return
+	case gpm.StatusCompleted, gpm.StatusSkipped, gpm.StatusDuplicate, gpm.StatusFailed, gpm.StatusVerifyFailed, gpm.StatusCanceled:
+	default:
+		return
+	}
+
+	r.counts[event.Status]++
+	if event.Status == gpm.StatusCompleted {
+		r.bytes += event.Bytes
+	}
+	row := &reportFile{
+		Path:             event.Path,
+		Status:           string(event.Status),
+		MediaKey:         event.MediaKey,
+		DedupKey:         event.DedupKey,
+		OriginalDedupKey: event.OriginalDedupKey,
+		CanonicalPath:    event.CanonicalPath,
+		Bytes:            event.Bytes,
+		Error:            errorString(event.Error),
+	}
+	if event.MediaKey != "" {
+		row.ThumbnailURL = r.thumbnail(event.MediaKey)
+		r.byKey[event.MediaKey] = append(r.byKey[event.MediaKey], row)
+	}
+	r.files = append(r.files, row)
+}
+
+// AddAlbum records media keys added to an album, err is set if adding them failed
+func (r *runReport) AddAlbum(name, key string, mediaKeys []string, err error) {
+	var album *reportAlbum
+	for _, a := range r.albums {
+		if a.Name == name {
+			album = a
+		}
+	}
+	if album == nil {
+		album = &reportAlbum{Name: name}
+		r.albums = append(r.albums, album)
+	}
+	if key != "" {
+		album.Key = key
+	}
+	if err != nil {
+		// Files of a failed batch share the error, list it once
+		if !slices.Contains(album.Errors, err.Error()) {
+			album.Errors = append(album.Errors, err.Error())
+		}
+		for _, mediaKey := range mediaKeys {
+			for _, row := range r.byKey[mediaKey] {
+				row.AlbumError = err.Error()
+			}
+		}
+		return
+	}
+	album.Items += len(mediaKeys)
+	for _, mediaKey := range mediaKeys {
+		for _, row := range r.byKey[mediaKey] {
+			row.Album = name
+		}
+	}
+}
+
+// Write writes the report in the format given by its extension
+func (r *runReport) Write() error {
+	r.end = time.Now()
+	data := r.data()
+
+	file, err := os.Create(r.path)
+	if err != nil {
+		return fmt.Errorf("failed to create report: %w", err)
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(r.path)) {
+	case ".json":
+		enc := json.NewEncoder(file)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(data)
+	case ".csv":
+		if err = writeCSVReport(file, data); err == nil {
+			err = r.writeCSVSummary(data)
+		}
+	default:
+		err = reportTemplate.Execute(file, data)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return file.Close()
+}
+
+func (r *runReport) data() reportData {
+	elapsed := r.end.Sub(r.start)
+	data := reportData{
+		Version:        outputVersion,
+		StartedAt:      r.start,
+		FinishedAt:     r.end,
+		ElapsedSeconds: elapsed.Seconds(),
+		Totals:         make(map[string]int, len(r.counts)),
+		Bytes:          r.bytes,
+		Albums:         r.albums,
+		Files:          r.files,
+	}
+	for status, count := range r.counts {
+		data.Totals[string(status)] = count
+	}
+	if secs := elapsed.Seconds(); secs > 0 {
+		data.BytesPerSecond = float64(r.bytes) / secs
+		data.FilesPerSecond = float64(len(r.files)) / secs
+	}
+	for _, row := range r.files {
+		if row.Status == string(gpm.StatusFailed) || row.Status == string(gpm.StatusVerifyFailed) {
+			data.Failed = append(data.Failed, row)
+		}
+	}
+	return data
+}
+
+// FormattedBytes returns the uploaded size for display
+func (d reportData) FormattedBytes() string {
+	return formatBytes(d.Bytes)
+}
+
+// FormattedElapsed returns the run duration for display
+func (d reportData) FormattedElapsed() string {
+	return time.Duration(d.ElapsedSeconds * float64(time.Second)).Round(time.Second).String()
+}
+
+// Throughput returns the upload rate for display
+func (d reportData) Throughput() string {
+	return fmt.Sprintf("%s/s, %.2f files/s", formatBytes(int64(d.BytesPerSecond)), d.FilesPerSecond)
+}
+
+// writeCSVReport writes one row per file
+func writeCSVReport(w io.Writer, data reportData) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"path", "status", "media_key", "dedup_key", "original_dedup_key", "thumbnail_url", "album", "album_error", "metadata_error", "canonical_path", "bytes", "error"})
+	for _, row := range data.Files {
+		cw.Write([]string{
+			row.Path, row.Status, row.MediaKey, row.DedupKey, row.OriginalDedupKey, row.ThumbnailURL, row.Album, row.AlbumError,
+			row.MetadataError, row.CanonicalPath, strconv.FormatInt(row.Bytes, 10), row.Error,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// summaryPath returns the path of the summary written next to a CSV report
+func (r *runReport) summaryPath() string {
+	return strings.TrimSuffix(r.path, filepath.Ext(r.path)) + ".summary" + filepath.Ext(r.path)
+}
+
+// writeCSVSummary writes the run totals, elapsed time and throughput of a CSV report
+// to a separate metric,value file, keeping the report itself one row per file
+func (r *runReport) writeCSVSummary(data reportData) error {
+	file, err := os.Create(r.summaryPath())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	cw := csv.NewWriter(file)
+	cw.Write([]string{"metric", "value"})
+	cw.Write([]string{"started_at", data.StartedAt.Format(time.RFC3339)})
+	cw.Write([]string{"finished_at", data.FinishedAt.Format(time.RFC3339)})
+	cw.Write([]string{"elapsed_seconds", strconv.FormatFloat(data.ElapsedSeconds, 'f', 3, 64)})
+	cw.Write([]string{"files", strconv.Itoa(len(data.Files))})
+	for _, status := range slices.Sorted(maps.Keys(data.Totals)) {
+		cw.Write([]string{status, strconv.Itoa(data.Totals[status])})
+	}
+	cw.Write([]string{"bytes", strconv.FormatInt(data.Bytes, 10)})
+	cw.Write([]string{"bytes_per_second", strconv.FormatFloat(data.BytesPerSecond, 'f', 0, 64)})
+	cw.Write([]string{"files_per_second", strconv.FormatFloat(data.FilesPerSecond, 'f', 2, 64)})
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// formatBytes formats a byte count with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gpcli upload report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+td.status-failed, td.status-verify_failed { color: #b00; }
+img { max-width: 96px; max-height: 96px; }
+</style>
+</head>
+<body>
+<h1>Upload report</h1>
+<table>
+<tr><th>Started</th><td>{{.StartedAt.Format "2006-01-02 15:04:05"}}</td></tr>
+<tr><th>Elapsed</th><td>{{.FormattedElapsed}}</td></tr>
+<tr><th>Uploaded</th><td>{{.FormattedBytes}}</td></tr>
+<tr><th>Throughput</th><td>{{.Throughput}}</td></tr>
+{{range $status, $count := .Totals}}<tr><th>{{$status}}</th><td>{{$count}}</td></tr>
+{{end}}</table>
+{{if .Albums}}<h2>Albums</h2>
+<table>
+<tr><th>Album</th><th>Key</th><th>Items</th><th>Errors</th></tr>
+{{range .Albums}}<tr><td>{{.Name}}</td><td>{{.Key}}</td><td>{{.Items}}</td><td>{{range .Errors}}{{.}}<br>{{end}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Failed}}<h2>Failed files</h2>
+<table>
+<tr><th>Path</th><th>Error</th></tr>
+{{range .Failed}}<tr><td>{{.Path}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>
+{{end}}<h2>Files</h2>
+<table>
+<tr><th>Thumbnail</th><th>Path</th><th>Status</th><th>Media key</th><th>Album</th></tr>
+{{range .Files}}<tr><td>{{if .ThumbnailURL}}<a href="{{.ThumbnailURL}}"><img src="{{.ThumbnailURL}}" alt="" loading="lazy"></a>{{end}}</td><td>{{.Path}}{{if .CanonicalPath}}<br><small>duplicate of {{.CanonicalPath}}</small>{{end}}</td><td class="status-{{.Status}}">{{.Status}}{{if .MetadataError}}<br><small>metadata not set: {{.MetadataError}}</small>{{end}}</td><td>{{.MediaKey}}</td><td>{{.Album}}{{if .AlbumError}}<small>{{.AlbumError}}</small>{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
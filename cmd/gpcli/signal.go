@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// interruptHandler is run on the first SIGINT/SIGTERM instead of cancelling the command
+var interruptHandler struct {
+	sync.Mutex
+	fn func()
+}
+
+// onInterrupt makes the first signal call fn (e.g. to stop an upload gracefully)
+// instead of cancelling the command context. The returned function restores the default.
+func onInterrupt(fn func()) (restore func()) {
+	interruptHandler.Lock()
+	interruptHandler.fn = fn
+	interruptHandler.Unlock()
+	return func() {
+		interruptHandler.Lock()
+		interruptHandler.fn = nil
+		interruptHandler.Unlock()
+	}
+}
+
+// handleSignals returns a context that is cancelled on the first SIGINT/SIGTERM,
+// unless a handler registered with onInterrupt takes over. A second signal exits immediately.
+func handleSignals(ctx context.Context) context.Context {
+	ctx, cancel := context.WithCancel(ctx)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+		interruptHandler.Lock()
+		fn := interruptHandler.fn
+		interruptHandler.Unlock()
+		if fn != nil {
+			logger.Warn("interrupted, finishing files in progress (press Ctrl-C again to exit now)")
+			fn()
+		} else {
+			cancel()
+		}
+
+		<-signals
+		logger.Error("interrupted again, exiting")
+		os.Exit(130)
+	}()
+	return ctx
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -16,12 +21,66 @@ import (
 
 func uploadAction(ctx context.Context, cmd *cli.Command) error {
 	filePath := cmd.StringArg("filepath")
-
-	// Validate that filepath exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+	retryFailed := cmd.String("retry-failed")
+	isURL := gpm.IsURL(filePath)
+
+	// Files with per-file metadata from a list
+	var listItems []gpm.UploadItem
+	fromFile := cmd.String("from-file")
+
+	// Files to retry from a previous run's manifest
+	var retryFiles []string
+	if fromFile != "" {
+		if filePath != "" || retryFailed != "" {
+			return fmt.Errorf("--from-file cannot be combined with a filepath argument or --retry-failed")
+		}
+		if cmd.Bool("check") {
+			return fmt.Errorf("--check is not supported with --from-file")
+		}
+		var err error
+		listItems, err = readUploadList(fromFile)
+		if err != nil {
+			return err
+		}
+		if len(listItems) == 0 {
+			logger.Info("no files to upload", "list", fromFile)
+			return nil
+		}
+	} else if retryFailed != "" {
+		if filePath != "" {
+			return fmt.Errorf("--retry-failed cannot be combined with a filepath argument")
+		}
+		var err error
+		retryFiles, err = readFailedManifest(retryFailed)
+		if err != nil {
+			return err
+		}
+		if len(retryFiles) == 0 {
+			logger.Info("no files to retry", "manifest", retryFailed)
+			return nil
+		}
+	} else if filePath == "" {
+		return fmt.Errorf("filepath is required (or use --from-file or --retry-failed)")
+	} else if isURL {
+		if cmd.Bool("check") {
+			return fmt.Errorf("--check is not supported for URLs")
+		}
+	} else if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		// Validate that filepath exists
 		return fmt.Errorf("file or directory does not exist: %s", filePath)
 	}
 
+	// Machine-readable output goes to stdout, logs move to stderr
+	output, err := newEventWriter(cmd.String("output"), os.Stdout)
+	if err != nil {
+		return err
+	}
+	if output != nil {
+		logToStderr()
+	}
+
+	var deletions *deletionReport
+
 	// Load config
 	if err := loadConfig(); err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
@@ -78,16 +137,62 @@ func uploadAction(ctx context.Context, cmd *cli.Command) error {
 
 	// Build upload options from CLI flags
 	uploadOpts := gpm.UploadOptions{
-		Workers:         threads,
-		Recursive:       cmd.Bool("recursive"),
-		ForceUpload:     cmd.Bool("force"),
-		DeleteFromHost:  cmd.Bool("delete"),
-		DisableFilter:   cmd.Bool("disable-filter"),
-		Caption:         cmd.String("caption"),
-		ShouldFavourite: cmd.Bool("favourite"),
-		ShouldArchive:   cmd.Bool("archive"),
-		Quality:         quality,
-		UseQuota:        cmd.Bool("use-quota") || accountUseQuota,
+		Workers:           threads,
+		HashWorkers:       int(cmd.Int("hash-threads")),
+		CheckWorkers:      int(cmd.Int("check-threads")),
+		CommitWorkers:     int(cmd.Int("commit-threads")),
+		Recursive:         cmd.Bool("recursive"),
+		FollowSymlinks:    cmd.Bool("follow-symlinks"),
+		SkipHidden:        cmd.Bool("skip-hidden"),
+		OneFileSystem:     cmd.Bool("one-file-system"),
+		ForceUpload:       cmd.Bool("force"),
+		DisableFilter:     cmd.Bool("disable-filter"),
+		Caption:           cmd.String("caption"),
+		ShouldFavourite:   cmd.Bool("favourite"),
+		ShouldArchive:     cmd.Bool("archive"),
+		Quality:           quality,
+		UseQuota:          cmd.Bool("use-quota") || accountUseQuota,
+		Retries:           int(cmd.Int("retries")),
+		RetryBackoff:      cmd.Duration("retry-backoff"),
+		Verify:            cmd.Bool("verify"),
+		MoveTo:            cmd.String("move-to"),
+		DeleteAfter:       cmd.Duration("delete-after"),
+		DeleteTransformed: cmd.Bool("delete-transformed"),
+	}
+	if mode, ok := cmd.Value("delete").(gpm.DeleteMode); ok {
+		uploadOpts.Delete = mode
+	}
+	if !cmd.Bool("no-transforms") {
+		transforms, err := cfgManager.GetTransforms()
+		if err != nil {
+			return fmt.Errorf("invalid transform config: %w", err)
+		}
+		uploadOpts.Transforms = transforms
+	}
+	// Metadata scrubbing runs last, after any format conversion
+	if spec := cmd.String("strip-metadata"); spec != "" {
+		scrub, err := gpm.ParseScrubTransform(spec)
+		if err != nil {
+			return err
+		}
+		uploadOpts.Transforms = append(uploadOpts.Transforms, scrub)
+	}
+
+	// Refuse to quarantine into the tree being uploaded
+	if uploadOpts.MoveTo != "" && filePath != "" && !isURL {
+		if err := checkMoveTarget(filePath, uploadOpts.MoveTo); err != nil {
+			return err
+		}
+	}
+
+	// Deletion report is appended to as files are removed
+	if reportPath := cmd.String("deletion-report"); reportPath != "" {
+		report, err := openDeletionReport(reportPath)
+		if err != nil {
+			return err
+		}
+		defer report.Close()
+		deletions = report
 	}
 
 	// Create API client
@@ -95,95 +200,269 @@ func uploadAction(ctx context.Context, cmd *cli.Command) error {
 	if err != nil {
 		return err
 	}
+	defer api.Close()
 
 	// Handle --check mode (dry run)
 	if cmd.Bool("check") {
-		return checkFiles(ctx, api, filePath, threads, uploadOpts.Recursive, uploadOpts.DisableFilter)
+		if retryFailed != "" {
+			return fmt.Errorf("--check cannot be combined with --retry-failed")
+		}
+		return checkFiles(ctx, api, filePath, threads, walkOptions(cmd), uploadOpts.DisableFilter)
 	}
 
-	// Log start
-	logger.Info("scanning files", "path", filePath)
+	// Run report is written when the command returns, whatever the outcome
+	var report *runReport
+	if reportPath := cmd.String("report"); reportPath != "" {
+		report, err = newRunReport(reportPath, func(mediaKey string) string {
+			return api.GetThumbnailURL(mediaKey, reportThumbnailWidth, 0, false, false)
+		})
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := report.Write(); err != nil {
+				logger.Warn("failed to write report", "error", err)
+			} else {
+				logger.Info("report written", "path", reportPath)
+			}
+		}()
+	}
+
+	// --datetime is the default for list entries without one, applied with their metadata
+	if timestamp != nil {
+		for i := range listItems {
+			if listItems[i].DateTime.IsZero() {
+				listItems[i].DateTime = *timestamp
+			}
+		}
+		if len(listItems) > 0 {
+			timestamp = nil
+		}
+	}
+
+	// Files are added to their albums in batches while uploading
+	albumNames := make(map[string]string) // Album keys to names
+	existingAlbum := func(name string) string {
+		key := cfgManager.GetAlbumKey(name)
+		if key != "" {
+			albumNames[key] = name
+		}
+		return key
+	}
+	if albumName != "" {
+		if key := existingAlbum(albumName); key != "" {
+			logger.Info("using existing album", "album", albumName, "key", key)
+			uploadOpts.AlbumKey = key
+		} else {
+			uploadOpts.AlbumName = albumName
+		}
+	}
+	for i, item := range listItems {
+		if item.AlbumName == "" {
+			continue
+		}
+		if key := existingAlbum(item.AlbumName); key != "" {
+			listItems[i].AlbumKey, listItems[i].AlbumName = key, ""
+		}
+	}
+	albumItems := make(map[string]int) // Files added by album name
+
+	// A duplicate shares the library item of an earlier file, so its own metadata is not set
+	listMetadata := make(map[string]bool)
+	for _, item := range listItems {
+		if item.Caption != "" || !item.DateTime.IsZero() || item.Location != nil || item.Favourite {
+			listMetadata[item.Path] = true
+		}
+	}
+	var albumFailed int
 
 	// Track results
-	var totalFiles, uploaded, existing, failed int
+	var totalFiles, uploaded, existing, duplicates, failed, metadataFailed int
+	var uploadedBytes int64
+	startTime := time.Now()
+	// Media keys are only kept to set --datetime, so they do not grow with the number of files otherwise
 	var successfulMediaKeys []string
+	var failedFiles []failedEntry
+	manifestPath := cmp.Or(cmd.String("failed-manifest"), defaultFailedManifest())
+	recordFailed := func(path, reason string) {
+		failedFiles = append(failedFiles, failedEntry{Path: path, Error: reason})
+	}
+
+	// Start upload from path or retry list
+	job := gpm.UploadJob{Options: uploadOpts}
+	if fromFile != "" {
+		logger.Info("uploading file list", "list", fromFile, "files", len(listItems))
+		job.Items = listItems
+	} else if retryFailed != "" {
+		logger.Info("retrying failed files", "manifest", retryFailed, "files", len(retryFiles))
+		job.Files = retryFiles
+	} else if isURL {
+		logger.Info("fetching url", "url", filePath)
+		job.Files = []string{filePath}
+	} else {
+		logger.Info("scanning files", "path", filePath)
+		job.Path = filePath
+	}
+	handle := api.Submit(ctx, job)
+	events := handle.Events()
+
+	// The first Ctrl-C stops starting new files, the second exits
+	var interrupted atomic.Bool
+	defer onInterrupt(func() {
+		interrupted.Store(true)
+		handle.Stop()
+	})()
+	var canceledFiles []string
 
 	// Process upload events
-	for event := range api.Upload(ctx, filePath, uploadOpts) {
+	for event := range events {
+		if output != nil {
+			if err := output.Event(event); err != nil {
+				logger.Warn("failed to write output", "error", err)
+			}
+		}
+		if report != nil {
+			report.Event(event)
+		}
 		if event.Total > 0 {
 			totalFiles = event.Total
-			logger.Info("starting upload", "files", totalFiles, "threads", threads)
+			logger.Info("files counted", "files", totalFiles, "threads", threads)
 		}
 
 		switch event.Status {
-		case gpm.StatusHashing, gpm.StatusUploading:
+		case gpm.StatusHashing:
 			logger.Debug(string(event.Status), "file", event.Path)
+		case gpm.StatusUploading:
+			if event.TransformedPath != "" {
+				logger.Debug(string(event.Status), "file", event.Path, "transformed", event.TransformedPath)
+			} else {
+				logger.Debug(string(event.Status), "file", event.Path)
+			}
 		case gpm.StatusCompleted:
 			uploaded++
-			progress := fmt.Sprintf("[%d/%d]", uploaded+existing+failed, totalFiles)
+			uploadedBytes += event.Bytes
+			progress := progressLabel(uploaded+existing+duplicates+failed, totalFiles)
 			logger.Info(progress+" uploaded", "mediaKey", event.MediaKey, "file", event.Path)
-			if event.MediaKey != "" {
+			if timestamp != nil && event.MediaKey != "" {
 				successfulMediaKeys = append(successfulMediaKeys, event.MediaKey)
 			}
+			logRemoval(event, deletions)
 		case gpm.StatusSkipped:
 			existing++
-			progress := fmt.Sprintf("[%d/%d]", uploaded+existing+failed, totalFiles)
+			progress := progressLabel(uploaded+existing+duplicates+failed, totalFiles)
 			logger.Info(progress+" skipped", "mediaKey", event.MediaKey, "file", event.Path, "exists", true)
-			if event.MediaKey != "" {
+			if timestamp != nil && event.MediaKey != "" {
 				successfulMediaKeys = append(successfulMediaKeys, event.MediaKey)
 			}
+			logRemoval(event, deletions)
+		case gpm.StatusDuplicate:
+			duplicates++
+			progress := progressLabel(uploaded+existing+duplicates+failed, totalFiles)
+			logger.Info(progress+" duplicate", "mediaKey", event.MediaKey, "file", event.Path, "of", event.CanonicalPath)
+			if listMetadata[event.Path] {
+				logger.Warn("metadata of duplicate not set", "file", event.Path, "reason", "same content as "+event.CanonicalPath)
+			}
+			logRemoval(event, deletions)
+		case gpm.StatusMetadataFailed:
+			metadataFailed++
+			logger.Warn("metadata not set", "mediaKey", event.MediaKey, "file", event.Path, "error", event.Error)
+		case gpm.StatusRetrying:
+			logger.Warn("retrying", "file", event.Path, "attempt", event.Attempt, "error", event.Error)
 		case gpm.StatusFailed:
 			failed++
-			progress := fmt.Sprintf("[%d/%d]", uploaded+existing+failed, totalFiles)
+			progress := progressLabel(uploaded+existing+duplicates+failed, totalFiles)
 			logger.Error(progress+" failed", "file", event.Path, "error", event.Error)
+			recordFailed(event.Path, errorString(event.Error))
+		case gpm.StatusVerifyFailed:
+			failed++
+			progress := progressLabel(uploaded+existing+duplicates+failed, totalFiles)
+			logger.Error(progress+" verification failed", "mediaKey", event.MediaKey, "file", event.Path, "error", event.Error)
+			recordFailed(event.Path, errorString(event.Error))
+		case gpm.StatusCanceled:
+			canceledFiles = append(canceledFiles, event.Path)
+			recordFailed(event.Path, "not processed: upload interrupted")
+		case gpm.StatusAlbumAdded:
+			name, known := albumNames[event.AlbumKey]
+			if !known {
+				// Store the mapping as soon as the album exists
+				name = event.AlbumName
+				albumNames[event.AlbumKey] = name
+				logger.Info("album created", "album", name, "key", event.AlbumKey)
+				if err := cfgManager.SetAlbumMapping(name, event.AlbumKey); err != nil {
+					logger.Warn("failed to store album mapping", "error", err)
+				}
+			}
+			albumItems[name]++
+			logger.Debug("added to album", "file", event.Path, "album", name)
+			if report != nil {
+				report.AddAlbum(name, event.AlbumKey, []string{event.MediaKey}, nil)
+			}
+		case gpm.StatusAlbumFailed:
+			albumFailed++
+			name := event.AlbumName
+			if name == "" {
+				name = albumNames[event.AlbumKey]
+			}
+			logger.Warn("failed to add to album", "file", event.Path, "album", name, "error", event.Error)
+			if report != nil {
+				report.AddAlbum(name, event.AlbumKey, []string{event.MediaKey}, event.Error)
+			}
 		default:
 			logger.Debug(string(event.Status), "file", event.Path, "mediaKey", event.MediaKey, "dedupKey", event.DedupKey, "error", event.Error)
 		}
 	}
 
+	// The total is counted separately from the upload walk, the files seen are authoritative
+	processed := uploaded + existing + duplicates + failed
+	counted := totalFiles > 0 || job.Path == ""
+	// A stopped job still knows the files its walk found, or counted but never reached
+	totalFiles = max(totalFiles, handle.Progress().Total)
+	if !interrupted.Load() {
+		totalFiles = max(totalFiles, processed)
+	}
+
 	// Print summary
-	logger.Info("upload complete", "uploaded", uploaded, "skipped", existing, "failed", failed)
+	if interrupted.Load() {
+		logger.Warn("upload interrupted", "uploaded", uploaded, "skipped", existing, "duplicates", duplicates, "failed", failed, "not_processed", len(canceledFiles))
+		for _, path := range canceledFiles {
+			logger.Warn("not processed", "file", path)
+		}
+		if job.Path != "" && totalFiles > processed+len(canceledFiles) {
+			logger.Warn("directory scan stopped early", "not_scanned", totalFiles-processed-len(canceledFiles))
+		} else if !counted {
+			logger.Warn("directory scan stopped early", "not_scanned", "unknown: files were still being counted")
+		}
+	} else {
+		logger.Info("upload complete", "uploaded", uploaded, "skipped", existing, "duplicates", duplicates, "failed", failed, "metadata_failed", metadataFailed)
+	}
+	if output != nil {
+		summary := uploadSummary{
+			Total: totalFiles, Uploaded: uploaded, Skipped: existing, Duplicates: duplicates, Failed: failed, MetadataFailed: metadataFailed,
+			Bytes: uploadedBytes, Elapsed: time.Since(startTime),
+			Interrupted: interrupted.Load(), NotProcessed: canceledFiles,
+		}
+		if err := output.Summary(summary); err != nil {
+			logger.Warn("failed to write output", "error", err)
+		}
+	}
 
-	// Handle album creation if album name was specified
-	if albumName != "" && len(successfulMediaKeys) > 0 {
-		const batchSize = 500
-		var albumKey string
-
-		// Check if album already exists in mappings
-		if existingKey := cfgManager.GetAlbumKey(albumName); existingKey != "" {
-			albumKey = existingKey
-			logger.Info("using existing album", "album", albumName, "key", albumKey)
-			// Add all media to existing album in batches
-			for i := 0; i < len(successfulMediaKeys); i += batchSize {
-				end := min(i+batchSize, len(successfulMediaKeys))
-				if err := api.AddMediaToAlbum(ctx, albumKey, successfulMediaKeys[i:end]); err != nil {
-					logger.Warn("failed to add batch to album", "error", err)
-				}
-			}
+	// Record failed files so they can be retried with --retry-failed
+	if len(failedFiles) > 0 {
+		if err := writeFailedManifest(manifestPath, failedFiles); err != nil {
+			logger.Warn("failed to write failed manifest", "error", err)
 		} else {
-			logger.Info("creating album", "album", albumName)
-			firstBatchEnd := min(batchSize, len(successfulMediaKeys))
-
-			var err error
-			albumKey, err = api.CreateAlbum(ctx, albumName, successfulMediaKeys[:firstBatchEnd])
-			if err != nil {
-				return fmt.Errorf("failed to create album: %w", err)
-			}
-
-			// Auto-store the mapping for future use
-			if err := cfgManager.SetAlbumMapping(albumName, albumKey); err != nil {
-				logger.Warn("failed to store album mapping", "error", err)
-			}
-
-			for i := batchSize; i < len(successfulMediaKeys); i += batchSize {
-				end := min(i+batchSize, len(successfulMediaKeys))
-				if err = api.AddMediaToAlbum(ctx, albumKey, successfulMediaKeys[i:end]); err != nil {
-					logger.Warn("failed to add batch to album", "error", err)
-				}
-			}
+			logger.Info("failed files written", "manifest", manifestPath, "retry", "gpcli upload --retry-failed "+manifestPath)
 		}
+	} else if len(failedFiles) == 0 && retryFailed != "" && samePath(retryFailed, manifestPath) {
+		// All retried files succeeded, the manifest is stale
+		os.Remove(manifestPath)
+	}
 
-		logger.Info("album ready", "album", albumName, "items", len(successfulMediaKeys))
+	for _, name := range slices.Sorted(maps.Keys(albumItems)) {
+		logger.Info("album ready", "album", name, "key", cfgManager.GetAlbumKey(name), "items", albumItems[name])
+	}
+	if albumFailed > 0 {
+		logger.Warn("some files were not added to their album", "files", albumFailed)
 	}
 
 	// Handle datetime setting if timestamp was specified
@@ -201,15 +480,98 @@ func uploadAction(ctx context.Context, cmd *cli.Command) error {
 		logger.Info("datetime set successfully", "count", len(successfulMediaKeys))
 	}
 
+	if interrupted.Load() {
+		return fmt.Errorf("upload interrupted")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed to upload", failed, totalFiles)
+	}
+	if metadataFailed > 0 {
+		return fmt.Errorf("%d of %d files were uploaded without all of their metadata", metadataFailed, totalFiles)
+	}
+	return nil
+}
+
+// logRemoval logs the local file removal outcome of an event and records it in the deletion report
+func logRemoval(event gpm.UploadEvent, deletions *deletionReport) {
+	if errors.Is(event.DeleteError, gpm.ErrOriginalKept) {
+		logger.Warn("local file kept", "file", event.Path, "reason", "only a transformed copy was uploaded, use --delete-transformed to remove it")
+		return
+	}
+	if event.DeleteError != nil {
+		logger.Warn("local file kept", "file", event.Path, "error", event.DeleteError)
+		return
+	}
+	if !event.Removed {
+		return
+	}
+
+	entry := deletionEntry{Path: event.Path, MediaKey: event.MediaKey, Action: "deleted"}
+	if event.MovedTo != "" {
+		entry.Action = "moved"
+		entry.MovedTo = event.MovedTo
+		logger.Debug("moved local file", "file", event.Path, "to", event.MovedTo)
+	} else {
+		logger.Debug("deleted local file", "file", event.Path)
+	}
+	if deletions != nil {
+		if err := deletions.Add(entry); err != nil {
+			logger.Warn("failed to write deletion report", "error", err)
+		}
+	}
+}
+
+// checkMoveTarget ensures the quarantine directory is not inside the upload source
+func checkMoveTarget(source, moveTo string) error {
+	absSource, err := filepath.Abs(source)
+	if err != nil {
+		return err
+	}
+	absMoveTo, err := filepath.Abs(moveTo)
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(absSource, absMoveTo); err == nil && (rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))) {
+		return fmt.Errorf("--move-to directory must not be inside the upload path")
+	}
 	return nil
 }
 
-func checkFiles(ctx context.Context, api *gpm.GooglePhotosAPI, path string, threads int, recursive, disableFilter bool) error {
+// progressLabel formats "[done/total]", with "?" while the total is still being counted
+func progressLabel(done, total int) string {
+	if total == 0 {
+		return fmt.Sprintf("[%d/?]", done)
+	}
+	return fmt.Sprintf("[%d/%d]", done, total)
+}
+
+// walkOptions builds the directory walk settings from CLI flags
+func walkOptions(cmd *cli.Command) gpm.WalkOptions {
+	return gpm.WalkOptions{
+		Recursive:      cmd.Bool("recursive"),
+		FollowSymlinks: cmd.Bool("follow-symlinks"),
+		SkipHidden:     cmd.Bool("skip-hidden"),
+		OneFileSystem:  cmd.Bool("one-file-system"),
+	}
+}
+
+// scanFiles lists the files to process, logging entries that could not be read
+func scanFiles(path string, opts gpm.WalkOptions, disableFilter bool) ([]string, error) {
 	logger.Info("scanning files", "path", path)
+	files, skipped, err := gpm.FindGooglePhotosFiles(path, opts, disableFilter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan files: %w", err)
+	}
+	for _, err := range skipped {
+		logger.Warn("skipped unreadable entry", "error", err)
+	}
+	return files, nil
+}
 
-	files, err := gpm.GetGooglePhotosSupportedFiles(path, recursive, disableFilter)
+func checkFiles(ctx context.Context, api *gpm.GooglePhotosAPI, path string, threads int, walk gpm.WalkOptions, disableFilter bool) error {
+	files, err := scanFiles(path, walk, disableFilter)
 	if err != nil {
-		return fmt.Errorf("failed to scan files: %w", err)
+		return err
 	}
 	if len(files) == 0 {
 		logger.Info("no supported files found")
@@ -273,3 +635,31 @@ func checkFiles(ctx context.Context, api *gpm.GooglePhotosAPI, path string, thre
 	logger.Info("check complete", "would_upload", wouldUpload.Load(), "exists", exists.Load(), "failed", failed.Load())
 	return nil
 }
+
+// deleteModeValue is a cli.Value for --delete that works both as a plain
+// switch (--delete, same as --delete=always) and with a mode (--delete=verify)
+type deleteModeValue struct {
+	mode gpm.DeleteMode
+}
+
+func (v *deleteModeValue) Set(s string) error {
+	mode, err := gpm.ParseDeleteMode(s)
+	if err != nil {
+		return err
+	}
+	v.mode = mode
+	return nil
+}
+
+func (v *deleteModeValue) String() string {
+	return string(v.mode)
+}
+
+func (v *deleteModeValue) Get() any {
+	return v.mode
+}
+
+// IsBoolFlag allows --delete without a value
+func (v *deleteModeValue) IsBoolFlag() bool {
+	return true
+}
@@ -0,0 +1,206 @@
+package gpm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/viperadnan-git/go-gpm/internal/core"
+)
+
+// hashGroups tracks files of a job by content hash so each unique file is uploaded once.
+// Files are grouped as they are hashed rather than in a pass over the whole job before
+// uploading: the first file with a hash claims it before any request is made for it, and
+// later files with that hash wait for its result and are reported as duplicates.
+// Only the most recent maxFinishedGroups finished groups are kept, so memory does not grow
+// with the tree: a duplicate of an evicted group is checked against the library like any
+// other file, and is reported as skipped rather than as a duplicate.
+type hashGroups struct {
+	mu       sync.Mutex
+	groups   map[string]*hashGroup
+	finished []string // Keys of finished groups, oldest first
+}
+
+// maxFinishedGroups bounds the finished groups kept to report later duplicates
+const maxFinishedGroups = 10000
+
+// hashGroup is the canonical file for a hash and the duplicates waiting on its result
+type hashGroup struct {
+	canonical  string
+	done       bool
+	mediaKey   string
+	fresh      bool // Canonical was uploaded in this run (not already in the library)
+	err        error
+	duplicates []duplicateFile
+}
+
+// duplicateFile is a file whose content matches an earlier file of the same job
+type duplicateFile struct {
+	path     string
+	workerID int
+	src      uploadSource
+	start    time.Time
+}
+
+func newHashGroups() *hashGroups {
+	return &hashGroups{groups: make(map[string]*hashGroup)}
+}
+
+// claim registers dup under dedupKey. It returns true if dup is the first file with
+// this content and should be uploaded. Otherwise dup is queued as a duplicate, unless the
+// canonical file has already finished, in which case its group is returned for reporting.
+func (g *hashGroups) claim(dedupKey string, dup duplicateFile) (bool, *hashGroup) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	group, ok := g.groups[dedupKey]
+	if !ok {
+		g.groups[dedupKey] = &hashGroup{canonical: dup.path}
+		return true, nil
+	}
+	if group.done {
+		return false, group
+	}
+	group.duplicates = append(group.duplicates, dup)
+	return false, nil
+}
+
+// finish records the canonical file's result and returns the duplicates waiting on it
+func (g *hashGroups) finish(dedupKey, mediaKey string, fresh bool, err error) (*hashGroup, []duplicateFile) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	group := g.groups[dedupKey]
+	group.done = true
+	group.mediaKey = mediaKey
+	group.fresh = fresh
+	group.err = err
+	duplicates := group.duplicates
+	group.duplicates = nil
+
+	g.finished = append(g.finished, dedupKey)
+	if len(g.finished) > maxFinishedGroups {
+		delete(g.groups, g.finished[0])
+		g.finished = g.finished[1:]
+	}
+	return group, duplicates
+}
+
+// reportDuplicate emits the outcome for a duplicate once its canonical file has finished.
+// Duplicates of a failed file fail with the same error, those of a canceled file are canceled.
+func reportDuplicate(ctx context.Context, api *core.Api, group *hashGroup, dup duplicateFile, dedupKey, root string, opts UploadOptions, deliver func(UploadEvent)) {
+	event := UploadEvent{
+		Path: dup.path, Status: StatusDuplicate, MediaKey: group.mediaKey, DedupKey: dedupKey,
+		CanonicalPath: group.canonical, WorkerID: dup.workerID, Bytes: dup.src.size,
+	}
+	if errors.Is(group.err, errJobStopped) {
+		event.Status = StatusCanceled
+		event.MediaKey = ""
+	} else if group.err != nil {
+		event.Status = StatusFailed
+		event.MediaKey = ""
+		event.Error = fmt.Errorf("duplicate of %s: %w", group.canonical, group.err)
+	} else {
+		event.Removed, event.MovedTo, event.DeleteError = cleanupHost(ctx, api, dup.path, root, group.mediaKey, &dup.src, group.fresh, opts)
+	}
+	event.Duration = time.Since(dup.start)
+	deliver(event)
+}
+
+// DuplicateGroup is a set of local files with identical content
+type DuplicateGroup struct {
+	DedupKey string
+	Size     int64
+	Paths    []string // In input order
+}
+
+// FindDuplicates groups files with identical content without contacting the server.
+// Only files sharing a size are hashed, using the given number of workers. Files that
+// cannot be read are left out, and their errors are returned joined along with the groups
+// of the other files.
+func FindDuplicates(ctx context.Context, files []string, workers int) ([]DuplicateGroup, error) {
+	if workers <= 0 {
+		workers = defaultUploadWorkers
+	}
+
+	// Group by size first, distinct sizes cannot be duplicates
+	sizes := make(map[string]int64, len(files))
+	bySize := make(map[int64][]string)
+	var skipped []error
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			skipped = append(skipped, fmt.Errorf("failed to stat %s: %w", path, err))
+			continue
+		}
+		sizes[path] = info.Size()
+		bySize[info.Size()] = append(bySize[info.Size()], path)
+	}
+
+	var candidates []string
+	for _, paths := range bySize {
+		if len(paths) > 1 {
+			candidates = append(candidates, paths...)
+		}
+	}
+
+	// Hash candidates concurrently
+	keys := make([]string, len(candidates))
+	errs := make([]error, len(candidates))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				hash, err := CalculateSHA1(ctx, candidates[i])
+				if err != nil {
+					errs[i] = fmt.Errorf("failed to hash %s: %w", candidates[i], err)
+					continue
+				}
+				keys[i] = core.SHA1ToDedupeKey(hash)
+			}
+		}()
+	}
+	for i := range candidates {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Keep the input order within and across groups
+	order := make(map[string]int, len(files))
+	for i, path := range files {
+		order[path] = i
+	}
+	groups := make(map[string]*DuplicateGroup)
+	for i, path := range candidates {
+		if errs[i] != nil {
+			skipped = append(skipped, errs[i])
+			continue
+		}
+		group, ok := groups[keys[i]]
+		if !ok {
+			group = &DuplicateGroup{DedupKey: keys[i], Size: sizes[path]}
+			groups[keys[i]] = group
+		}
+		group.Paths = append(group.Paths, path)
+	}
+
+	var result []DuplicateGroup
+	for _, group := range groups {
+		if len(group.Paths) < 2 {
+			continue
+		}
+		sort.Slice(group.Paths, func(i, j int) bool { return order[group.Paths[i]] < order[group.Paths[j]] })
+		result = append(result, *group)
+	}
+	sort.Slice(result, func(i, j int) bool { return order[result[i].Paths[0]] < order[result[j].Paths[0]] })
+	return result, errors.Join(skipped...)
+}
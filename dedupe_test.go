@@ -0,0 +1,71 @@
+package gpm
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHashGroups(t *testing.T) {
+	g := newHashGroups()
+	if canonical, _ := g.claim("a", duplicateFile{path: "a1"}); !canonical {
+		t.Fatal("first file of a hash is not canonical")
+	}
+	if canonical, group := g.claim("a", duplicateFile{path: "a2"}); canonical || group != nil {
+		t.Fatalf("claim = %v, %v; want a2 queued behind a1", canonical, group)
+	}
+	group, duplicates := g.finish("a", "key", true, nil)
+	if len(duplicates) != 1 || duplicates[0].path != "a2" {
+		t.Fatalf("finish returned %v, want the queued a2", duplicates)
+	}
+	if canonical, late := g.claim("a", duplicateFile{path: "a3"}); canonical || late != group {
+		t.Fatalf("claim after finish = %v, %v; want the finished group", canonical, late)
+	}
+}
+
+func TestHashGroupsEvictsFinished(t *testing.T) {
+	g := newHashGroups()
+	for i := range maxFinishedGroups + 1 {
+		key := strconv.Itoa(i)
+		g.claim(key, duplicateFile{path: key})
+		g.finish(key, "key", true, nil)
+	}
+	if len(g.groups) != maxFinishedGroups {
+		t.Fatalf("kept %d groups, want %d", len(g.groups), maxFinishedGroups)
+	}
+	// The oldest group was evicted, so its hash is claimed again
+	if canonical, _ := g.claim("0", duplicateFile{path: "late"}); !canonical {
+		t.Error("duplicate of an evicted group was not claimed as canonical")
+	}
+	if canonical, _ := g.claim("1", duplicateFile{path: "late"}); canonical {
+		t.Error("duplicate of a kept group was claimed as canonical")
+	}
+}
+
+func TestFindDuplicatesSkipsUnreadable(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	a, b := write("a.jpg", "same"), write("b.jpg", "same")
+	other := write("c.jpg", "diff")
+	missing := filepath.Join(dir, "missing.jpg")
+
+	groups, err := FindDuplicates(context.Background(), []string{a, missing, other, b}, 2)
+	if !errors.Is(err, fs.ErrNotExist) || !strings.Contains(err.Error(), missing) {
+		t.Fatalf("err = %v, want the stat error of %s", err, missing)
+	}
+	if len(groups) != 1 || !slices.Equal(groups[0].Paths, []string{a, b}) {
+		t.Fatalf("groups = %+v, want [%s %s]", groups, a, b)
+	}
+}
@@ -0,0 +1,166 @@
+package gpm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/viperadnan-git/go-gpm/internal/core"
+)
+
+// DeleteMode controls when local files are removed after upload
+type DeleteMode string
+
+const (
+	DeleteNever    DeleteMode = ""       // Keep local files
+	DeleteAlways   DeleteMode = "always" // Remove after upload or when already in library
+	DeleteVerified DeleteMode = "verify" // Remove only after the server copy has been verified
+)
+
+// ErrOriginalKept is the DeleteError of a file that was transformed before upload: only the
+// modified copy is in the library, so the original is kept unless UploadOptions.DeleteTransformed is set
+var ErrOriginalKept = errors.New("original kept: only a transformed copy was uploaded")
+
+// ParseDeleteMode parses a delete mode string ("", "false", "true", "always" or "verify")
+func ParseDeleteMode(s string) (DeleteMode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "false", "never":
+		return DeleteNever, nil
+	case "true", "always":
+		return DeleteAlways, nil
+	case "verify":
+		return DeleteVerified, nil
+	default:
+		return DeleteNever, fmt.Errorf("invalid delete mode: %s (use 'always' or 'verify')", s)
+	}
+}
+
+// cleanupHost applies the delete policy to a local file stored in the library as mediaKey.
+// fresh is true when the file was uploaded (and verified, if required) in this run.
+// Returns whether the file was removed and its quarantine path if moved.
+func cleanupHost(ctx context.Context, api *core.Api, filePath, root, mediaKey string, src *uploadSource, fresh bool, opts UploadOptions) (bool, string, error) {
+	mode := opts.deleteMode()
+	if mode == DeleteNever || src.remote {
+		return false, "", nil
+	}
+	if src.transformed && !opts.DeleteTransformed {
+		return false, "", ErrOriginalKept
+	}
+
+	// A fresh upload has not been in the library for any grace period yet
+	if fresh && opts.DeleteAfter > 0 {
+		return false, "", nil
+	}
+
+	// Existing items were matched by hash only, verify the stored copy like a fresh upload
+	if !fresh && (mode == DeleteVerified || opts.DeleteAfter > 0) {
+		info, err := api.GetDownloadInfo(ctx, mediaKey)
+		if err != nil {
+			return false, "", &UploadError{Op: "verify", Err: err}
+		}
+		if mode == DeleteVerified {
+			if err := verifyItem(ctx, api, mediaKey, info, src, opts); err != nil {
+				return false, "", err
+			}
+		}
+		if opts.DeleteAfter > 0 && (info.UploadedAt.IsZero() || time.Since(info.UploadedAt) < opts.DeleteAfter) {
+			return false, "", nil
+		}
+	}
+
+	movedTo, err := removeFromHost(filePath, root, opts)
+	if err != nil {
+		return false, "", err
+	}
+	return true, movedTo, nil
+}
+
+// removeFromHost deletes the local file, or moves it below opts.MoveTo when set.
+// root is the directory the upload was started from, used to preserve the relative tree.
+// Returns the quarantine path for moved files.
+func removeFromHost(filePath, root string, opts UploadOptions) (string, error) {
+	if opts.MoveTo == "" {
+		if err := os.Remove(filePath); err != nil {
+			return "", fmt.Errorf("delete failed: %w", err)
+		}
+		return "", nil
+	}
+
+	dest := filepath.Join(opts.MoveTo, relativeUploadPath(filePath, root))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", fmt.Errorf("move failed: %w", err)
+	}
+	if _, err := os.Lstat(dest); err == nil {
+		return "", fmt.Errorf("move failed: %s already exists", dest)
+	}
+	if err := moveFile(filePath, dest); err != nil {
+		return "", fmt.Errorf("move failed: %w", err)
+	}
+	return dest, nil
+}
+
+// relativeUploadPath returns filePath relative to root.
+// Without a usable root, absolute paths are mirrored below the destination.
+func relativeUploadPath(filePath, root string) string {
+	if root != "" {
+		if rel, err := filepath.Rel(root, filePath); err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return rel
+		}
+	}
+	if abs, err := filepath.Abs(filePath); err == nil {
+		filePath = abs
+	}
+	filePath = strings.TrimPrefix(filePath, filepath.VolumeName(filePath))
+	return strings.TrimLeft(filePath, `/\`)
+}
+
+// moveFile renames src to dest, falling back to copy and delete across filesystems.
+// Other rename errors are returned as they are.
+func moveFile(src, dest string) error {
+	if err := os.Rename(src, dest); !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dest)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(dest)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dest)
+		return err
+	}
+	if err := os.Chtimes(dest, info.ModTime(), info.ModTime()); err != nil {
+		os.Remove(dest)
+		return err
+	}
+
+	in.Close()
+	return os.Remove(src)
+}
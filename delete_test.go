@@ -0,0 +1,61 @@
+package gpm
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeleteMode(t *testing.T) {
+	tests := []struct {
+		name string
+		opts UploadOptions
+		want DeleteMode
+	}{
+		{"default", UploadOptions{}, DeleteNever},
+		{"explicit", UploadOptions{Delete: DeleteVerified}, DeleteVerified},
+		{"deprecated field", UploadOptions{DeleteFromHost: true}, DeleteAlways},
+		{"delete wins over deprecated field", UploadOptions{Delete: DeleteVerified, DeleteFromHost: true}, DeleteVerified},
+		{"move", UploadOptions{MoveTo: "done"}, DeleteAlways},
+		{"delete after", UploadOptions{DeleteAfter: time.Hour}, DeleteAlways},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.deleteMode(); got != tt.want {
+				t.Fatalf("deleteMode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoveFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.jpg")
+	if err := os.WriteFile(src, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(dir, "b.jpg")
+	if err := moveFile(src, dest); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(dest); err != nil || string(got) != "data" {
+		t.Fatalf("moved file = %q, %v", got, err)
+	}
+
+	// A failed rename is not retried as a copy
+	err := moveFile(filepath.Join(dir, "missing.jpg"), filepath.Join(dir, "c.jpg"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("moveFile of a missing file = %v, want ErrNotExist", err)
+	}
+	err = moveFile(dest, filepath.Join(dir, "no", "such", "dir.jpg"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("moveFile into a missing directory = %v, want ErrNotExist", err)
+	}
+	if _, err := os.Stat(dest); err != nil {
+		t.Errorf("source of a failed move is gone: %v", err)
+	}
+}
@@ -0,0 +1,774 @@
+package gpm
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/viperadnan-git/go-gpm/internal/core"
+)
+
+// DownloadStatus represents the state of an item download
+type DownloadStatus string
+
+const (
+	DownloadStatusResolving   DownloadStatus = "resolving" // Looking up the media key and download URL
+	DownloadStatusDownloading DownloadStatus = "downloading"
+	DownloadStatusCompleted   DownloadStatus = "completed"
+	DownloadStatusSkipped     DownloadStatus = "skipped" // Target file kept (see ConflictPolicy)
+	DownloadStatusFailed      DownloadStatus = "failed"
+)
+
+// DownloadVersion selects which version of an edited item is downloaded
+type DownloadVersion string
+
+const (
+	DownloadVersionOriginal DownloadVersion = "original" // Original, or the edited version if there is no original URL (default)
+	DownloadVersionEdited   DownloadVersion = "edited"   // Edited version, or the original for unedited items
+	DownloadVersionBoth     DownloadVersion = "both"     // Original, plus the edited version saved alongside it
+)
+
+// DefaultEditedSuffix is added to edited copies saved with DownloadVersionBoth by default
+const DefaultEditedSuffix = "-edited"
+
+// ConflictPolicy decides what happens when a download's target file already exists
+type ConflictPolicy string
+
+const (
+	ConflictOverwrite  ConflictPolicy = "overwrite"   // Replace the file (default)
+	ConflictSkip       ConflictPolicy = "skip"        // Keep the file and skip the download
+	ConflictRename     ConflictPolicy = "rename"      // Save as name-2, name-3...
+	ConflictHashSuffix ConflictPolicy = "hash-suffix" // Save as name-<dedup key prefix> unless the file is identical
+)
+
+// hashSuffixLength is the length of the dedup key prefix added by ConflictHashSuffix
+const hashSuffixLength = 8
+
+// DownloadEvent represents a status update for an item download
+type DownloadEvent struct {
+	Input      string // Item key or file path as given
+	MediaKey   string
+	Status     DownloadStatus
+	Path       string // Saved file (set on DownloadStatusCompleted), or the existing file if skipped
+	EditedPath string // Edited copy saved alongside Path (DownloadVersionBoth)
+	Bytes      int64  // Size reported by the server, once known
+	Error      error
+	WorkerID   int
+	Total      int           // Items in batch (set on first event)
+	Duration   time.Duration // Time spent on the item so far
+	Verified   bool          // Hash matched the item's dedup key (with DownloadOptions.Verify, see CanVerifyDownload)
+	Edited     bool          // Path is the edited version, which cannot be verified
+}
+
+// DownloadOptions contains runtime options for bulk downloads
+type DownloadOptions struct {
+	Workers        int             // Concurrent downloads (default: 3)
+	OutputDir      string          // Directory for downloaded files, created if missing (default: current directory)
+	Verify         bool            // Check each download against its dedup key before saving it (see WithDownloadVerify)
+	Version        DownloadVersion // Version of edited items to download (default: original)
+	EditedSuffix   string          // Filename suffix of edited copies with DownloadVersionBoth (default: "-edited")
+	OutputTemplate string          // Path below OutputDir, see ParsePathTemplate (default: "{filename}")
+	OnConflict     ConflictPolicy  // What to do when a target file exists (default: overwrite)
+	Sidecar        SidecarFormat   // Metadata file to write next to each download (default: none)
+
+	// Download requests, see WithDownloadAuth and WithDownloadHeaders
+	Auth    bool              // Send the account's auth headers
+	Headers map[string]string // Extra request headers
+}
+
+// DownloadOption customises a download
+type DownloadOption func(*downloadConfig)
+
+// downloadConfig holds the settings of DownloadOptions
+type downloadConfig struct {
+	auth    bool
+	headers map[string]string
+	verify  bool
+}
+
+// newDownloadConfig applies opts
+func newDownloadConfig(opts []DownloadOption) downloadConfig {
+	var cfg downloadConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// WithDownloadAuth sends the account's auth headers with download requests. Download URLs
+// are signed and normally need none.
+func WithDownloadAuth() DownloadOption {
+	return func(c *downloadConfig) { c.auth = true }
+}
+
+// WithDownloadHeaders adds headers to download requests
+func WithDownloadHeaders(headers map[string]string) DownloadOption {
+	return func(c *downloadConfig) {
+		if c.headers == nil {
+			c.headers = make(map[string]string, len(headers))
+		}
+		maps.Copy(c.headers, headers)
+	}
+}
+
+// WithDownloadVerify checks the download with VerifyDownload, against info.MediaKey, while it
+// is still a .part file. A mismatching download is removed and never replaces the target;
+// downloads that cannot be verified (see CanVerifyDownload) are saved unverified.
+func WithDownloadVerify() DownloadOption {
+	return func(c *downloadConfig) { c.verify = true }
+}
+
+// downloadOptions returns the DownloadOption equivalents of o
+func (o DownloadOptions) downloadOptions() []DownloadOption {
+	var opts []DownloadOption
+	if o.Auth {
+		opts = append(opts, WithDownloadAuth())
+	}
+	if len(o.Headers) > 0 {
+		opts = append(opts, WithDownloadHeaders(o.Headers))
+	}
+	if o.Verify {
+		opts = append(opts, WithDownloadVerify())
+	}
+	return opts
+}
+
+// DownloadItems downloads media items, given as media keys, dedup keys or local file paths,
+// into a directory and returns a channel for status events. Items sharing a path within the
+// batch get a numbered suffix. The channel is closed when all downloads have finished;
+// events not yet received when ctx is done are dropped.
+func (g *GooglePhotosAPI) DownloadItems(ctx context.Context, inputs []string, opts DownloadOptions) <-chan DownloadEvent {
+	return g.downloadItems(ctx, inputs, opts, g.lookupItem)
+}
+
+// itemLookup resolves a bulk download input to its media key and download info, and
+// returns the SHA1 the lookup used (nil for a media key). The media key is set if only
+// getting the download info failed.
+type itemLookup func(ctx context.Context, input string) (mediaKey string, expected []byte, info *DownloadInfo, err error)
+
+// lookupItem is the itemLookup of DownloadItems
+func (g *GooglePhotosAPI) lookupItem(ctx context.Context, input string) (string, []byte, *DownloadInfo, error) {
+	mediaKey, expected, err := g.resolveMediaKey(ctx, input)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	info, err := g.GetDownloadInfo(ctx, mediaKey)
+	if err != nil {
+		return mediaKey, nil, nil, fmt.Errorf("failed to get download info: %w", err)
+	}
+	return mediaKey, expected, info, nil
+}
+
+// downloadItems is DownloadItems with the lookup of inputs passed in
+func (g *GooglePhotosAPI) downloadItems(ctx context.Context, inputs []string, opts DownloadOptions, lookup itemLookup) <-chan DownloadEvent {
+	events := make(chan DownloadEvent)
+	send := func(event DownloadEvent) {
+		select {
+		case events <- event:
+		case <-ctx.Done():
+		}
+	}
+	go func() {
+		defer close(events)
+		if len(inputs) == 0 {
+			return
+		}
+		if opts.OutputDir != "" {
+			if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
+				send(DownloadEvent{Status: DownloadStatusFailed, Error: fmt.Errorf("failed to create output directory: %w", err)})
+				return
+			}
+		}
+
+		template, err := ParsePathTemplate(cmp.Or(opts.OutputTemplate, "{filename}"))
+		if err != nil {
+			send(DownloadEvent{Status: DownloadStatusFailed, Error: err})
+			return
+		}
+		if err := opts.OnConflict.validate(); err != nil {
+			send(DownloadEvent{Status: DownloadStatusFailed, Error: err})
+			return
+		}
+		if opts.Sidecar != "" && opts.Sidecar != SidecarXMP && opts.Sidecar != SidecarJSON {
+			send(DownloadEvent{Status: DownloadStatusFailed, Error: fmt.Errorf("invalid sidecar format %q (use xmp or json)", opts.Sidecar)})
+			return
+		}
+
+		send(DownloadEvent{Total: len(inputs)})
+
+		work := make(chan string)
+		names := &claimedNames{names: make(map[string]bool)}
+		var wg sync.WaitGroup
+		for workerID := range min(workersOr(opts.Workers, defaultUploadWorkers), len(inputs)) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for input := range work {
+					g.downloadItem(ctx, input, workerID, opts, template, names, lookup, send)
+				}
+			}()
+		}
+		for _, input := range inputs {
+			if ctx.Err() != nil {
+				break
+			}
+			work <- input
+		}
+		close(work)
+		wg.Wait()
+	}()
+	return events
+}
+
+// downloadItem resolves and downloads one item of a bulk download
+func (g *GooglePhotosAPI) downloadItem(ctx context.Context, input string, workerID int, opts DownloadOptions, template *PathTemplate, names *claimedNames, lookup itemLookup, send func(DownloadEvent)) {
+	start := time.Now()
+	emit := func(event DownloadEvent) {
+		event.Input, event.WorkerID, event.Duration = input, workerID, time.Since(start)
+		send(event)
+	}
+
+	emit(DownloadEvent{Status: DownloadStatusResolving})
+	mediaKey, expected, info, err := lookup(ctx, input)
+	if err != nil {
+		emit(DownloadEvent{Status: DownloadStatusFailed, MediaKey: mediaKey, Error: err})
+		return
+	}
+
+	if info.Filename == "" {
+		info.Filename = mediaKey
+	}
+	versions, err := DownloadVersions(info, opts.Version, opts.EditedSuffix)
+	if err != nil {
+		emit(DownloadEvent{Status: DownloadStatusFailed, MediaKey: mediaKey, Error: err})
+		return
+	}
+
+	result := DownloadEvent{Status: DownloadStatusCompleted, MediaKey: mediaKey, Bytes: info.FileSize}
+	cfg := newDownloadConfig(opts.downloadOptions())
+	for i, version := range versions {
+		edited := IsEditedDownload(version)
+		if i == 0 {
+			emit(DownloadEvent{Status: DownloadStatusDownloading, MediaKey: mediaKey, Bytes: info.FileSize, Edited: edited})
+		}
+
+		data := templateData{info: version, mediaKey: mediaKey}
+		target := saveTarget{pathFor: func(dedupKey string) string {
+			data.dedupKey = dedupKey
+			return filepath.Join(opts.OutputDir, template.render(data))
+		}}
+		if template.uses("dedup_key") {
+			// Inputs resolving to the same item must not share a staging file
+			staged := filepath.Join(opts.OutputDir, "."+mediaKey)
+			if edited {
+				staged += DefaultEditedSuffix
+			}
+			target.staged = names.claim(staged, false)
+		}
+		saved, err := g.save(ctx, cfg, version, target, expected, opts.OnConflict, names)
+		if err != nil {
+			emit(DownloadEvent{Status: DownloadStatusFailed, MediaKey: mediaKey, Path: result.Path, Bytes: info.FileSize, Edited: edited, Error: err})
+			return
+		}
+		if opts.Sidecar != "" {
+			if _, err := WriteSidecar(saved.Path, mediaKey, version, opts.Sidecar); err != nil {
+				emit(DownloadEvent{Status: DownloadStatusFailed, MediaKey: mediaKey, Path: saved.Path, Bytes: info.FileSize, Edited: edited, Error: err})
+				return
+			}
+		}
+		if i == 0 {
+			result.Path, result.Verified, result.Edited = saved.Path, saved.Verified, edited
+			if saved.Skipped {
+				result.Status = DownloadStatusSkipped
+			}
+		} else {
+			result.EditedPath = saved.Path
+		}
+	}
+	emit(result)
+}
+
+// claimedNames hands out unique paths within a bulk download
+type claimedNames struct {
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+// claim returns path, or path with a "-2", "-3"... suffix if it was already claimed or,
+// with avoidExisting, exists on disk. A nil c only checks the disk.
+func (c *claimedNames) claim(path string, avoidExisting bool) string {
+	if c != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+	}
+	taken := func(candidate string) bool {
+		if c != nil && c.names[strings.ToLower(candidate)] {
+			return true
+		}
+		return avoidExisting && fileExists(candidate)
+	}
+	candidate := path
+	for n := 2; taken(candidate); n++ {
+		candidate = AddFilenameSuffix(path, "-"+strconv.Itoa(n))
+	}
+	if c != nil {
+		c.names[strings.ToLower(candidate)] = true
+	}
+	return candidate
+}
+
+// DownloadVersions returns the downloads to make for info with the given version: copies of
+// info with DownloadURL set accordingly. With DownloadVersionBoth an edited item also yields
+// its edited version, with suffix (default "-edited") added to the filename.
+func DownloadVersions(info *DownloadInfo, version DownloadVersion, suffix string) ([]*DownloadInfo, error) {
+	hasEdit := info.IsEdited && info.EditedURL != "" && info.EditedURL != info.OriginalURL
+	edited := *info
+	edited.DownloadURL = info.EditedURL
+
+	switch version {
+	case "", DownloadVersionOriginal:
+		return []*DownloadInfo{info}, nil
+	case DownloadVersionEdited:
+		if hasEdit {
+			return []*DownloadInfo{&edited}, nil
+		}
+		return []*DownloadInfo{info}, nil
+	case DownloadVersionBoth:
+		if !hasEdit || info.DownloadURL == info.EditedURL {
+			return []*DownloadInfo{info}, nil
+		}
+		if suffix == "" {
+			suffix = DefaultEditedSuffix
+		}
+		edited.Filename = AddFilenameSuffix(info.Filename, suffix)
+		return []*DownloadInfo{info, &edited}, nil
+	default:
+		return nil, fmt.Errorf("invalid download version %q (use original, edited or both)", version)
+	}
+}
+
+// AddFilenameSuffix inserts suffix before the extension of name, e.g. "a.jpg" and "-edited"
+// give "a-edited.jpg"
+func AddFilenameSuffix(name, suffix string) string {
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + suffix + ext
+}
+
+// partSuffix is appended to files while they are being downloaded. Next to an unfinished
+// part, a "<part>.state" file records what it belongs to (see partState).
+const (
+	partSuffix      = ".part"
+	partStateSuffix = ".state"
+)
+
+// validate checks that p is a known policy; empty means overwrite
+func (p ConflictPolicy) validate() error {
+	switch p {
+	case "", ConflictOverwrite, ConflictSkip, ConflictRename, ConflictHashSuffix:
+		return nil
+	}
+	return fmt.Errorf("invalid conflict policy %q (use skip, overwrite, rename or hash-suffix)", p)
+}
+
+// SavedFile is the outcome of SaveDownload
+type SavedFile struct {
+	Path     string // Saved file, or the existing file if skipped
+	SHA1     []byte // Hash of the downloaded data, for VerifyDownload (nil if skipped)
+	Skipped  bool   // An existing file was kept (see ConflictPolicy)
+	Verified bool   // The data matched the library item (with WithDownloadVerify)
+}
+
+// SaveDownload downloads the media item described by info to outputPath (a file, or a
+// directory to use the item's filename). Data is written to "<path>.part" and renamed
+// once complete. An existing .part file of the same item and version is resumed with a
+// Range request when the server supports it, sent with If-Range when the server gave a
+// validator for the part, and the size of originals is checked against info.FileSize. The download
+// goes through the API client, using its proxy, retries and ctx, and opts customise it.
+// onConflict decides what happens when the file exists. The file's times are set to
+// info.CreatedAt.
+func (g *GooglePhotosAPI) SaveDownload(ctx context.Context, info *DownloadInfo, outputPath string, onConflict ConflictPolicy, opts ...DownloadOption) (SavedFile, error) {
+	if err := onConflict.validate(); err != nil {
+		return SavedFile{}, err
+	}
+	filename := cmp.Or(info.Filename, extractFilenameFromURL(info.DownloadURL), "download")
+	path := resolveOutputPath(outputPath, filename)
+	return g.save(ctx, newDownloadConfig(opts), info, saveTarget{pathFor: func(string) string { return path }}, nil, onConflict, nil)
+}
+
+// saveTarget is where save puts a download
+type saveTarget struct {
+	pathFor func(dedupKey string) string // Target path; dedupKey is empty if not known
+	staged  string                       // Set if pathFor needs the dedup key: download here first
+}
+
+// save downloads info to its target as configured, applying the conflict policy. expected is
+// the item's SHA1 if known from the input. names, if set, keeps targets unique within a batch.
+func (g *GooglePhotosAPI) save(ctx context.Context, cfg downloadConfig, info *DownloadInfo, target saveTarget, expected []byte, policy ConflictPolicy, names *claimedNames) (SavedFile, error) {
+	size := info.FileSize
+	if IsEditedDownload(info) {
+		size = 0 // FileSize is the original's
+		expected = nil
+	}
+
+	// Settle the path before downloading when possible, so existing files need not be fetched
+	var path, partPath string
+	if target.staged != "" && expected == nil {
+		partPath = target.staged + partSuffix
+	} else {
+		path = names.claim(target.pathFor(dedupKeyOf(expected)), policy == ConflictRename)
+		if fileExists(path) {
+			switch {
+			case policy == ConflictSkip:
+				return SavedFile{Path: path, Skipped: true}, nil
+			case policy == ConflictHashSuffix && expected != nil:
+				if sameContent(ctx, path, expected) {
+					return SavedFile{Path: path, Skipped: true}, nil
+				}
+				path = hashSuffixed(path, expected)
+				if fileExists(path) {
+					return SavedFile{Path: path, Skipped: true}, nil
+				}
+			}
+		}
+		partPath = path + partSuffix
+	}
+
+	hash, err := fetch(ctx, g.opener(cfg), info.DownloadURL, partPath, downloadID(info), size)
+	if err != nil {
+		return SavedFile{}, err
+	}
+
+	// Verify before the data can replace anything at the target
+	var verified bool
+	if cfg.verify {
+		if verified, err = g.VerifyDownload(ctx, info.MediaKey, info, hash, expected); err != nil {
+			if errors.Is(err, ErrDownloadMismatch) {
+				removePart(partPath)
+			}
+			return SavedFile{}, err
+		}
+	}
+	if path == "" {
+		path = names.claim(target.pathFor(dedupKeyOf(hash)), policy == ConflictRename)
+	}
+
+	if fileExists(path) {
+		switch policy {
+		case ConflictSkip:
+			os.Remove(partPath)
+			return SavedFile{Path: path, Skipped: true}, nil
+		case ConflictHashSuffix:
+			if sameContent(ctx, path, hash) {
+				os.Remove(partPath)
+				return SavedFile{Path: path, Skipped: true}, nil
+			}
+			// The suffixed name holds identical data if it exists
+			if path = hashSuffixed(path, hash); fileExists(path) {
+				os.Remove(partPath)
+				return SavedFile{Path: path, Skipped: true}, nil
+			}
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return SavedFile{}, fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.Rename(partPath, path); err != nil {
+		return SavedFile{}, fmt.Errorf("failed to rename %s: %w", partPath, err)
+	}
+	// The file is saved at this point, so failing to date it does not fail the download
+	if err := restoreFileTimes(path, info); err != nil {
+		slog.Warn("download saved without its creation time", "file", path, "error", err)
+	}
+	return SavedFile{Path: path, SHA1: hash, Verified: verified}, nil
+}
+
+// hashSuffixed adds the start of the dedup key of hash to the filename of path
+func hashSuffixed(path string, hash []byte) string {
+	return AddFilenameSuffix(path, "-"+dedupKeyOf(hash)[:hashSuffixLength])
+}
+
+// dedupKeyOf returns the dedup key of a SHA1, empty for nil
+func dedupKeyOf(hash []byte) string {
+	if hash == nil {
+		return ""
+	}
+	return core.SHA1ToDedupeKey(hash)
+}
+
+// sameContent reports whether the file at path has the given SHA1
+func sameContent(ctx context.Context, path string, hash []byte) bool {
+	existing, err := CalculateSHA1(ctx, path)
+	return err == nil && bytes.Equal(existing, hash)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// OpenMedia resolves key (a media key, dedup key or file path) and opens the item's
+// download as a stream, so it can be piped elsewhere without a temporary file.
+// Caller is responsible for closing the returned ReadCloser.
+func (g *GooglePhotosAPI) OpenMedia(ctx context.Context, key string) (io.ReadCloser, *DownloadInfo, error) {
+	mediaKey, err := g.ResolveMediaKey(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := g.GetDownloadInfo(ctx, mediaKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get download info: %w", err)
+	}
+	body, err := g.OpenDownloadInfo(ctx, info)
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, info, nil
+}
+
+// OpenDownloadInfo opens the download described by info (e.g. a version from DownloadVersions)
+// as a stream, with opts customising the request. Caller is responsible for closing the
+// returned ReadCloser.
+func (g *GooglePhotosAPI) OpenDownloadInfo(ctx context.Context, info *DownloadInfo, opts ...DownloadOption) (io.ReadCloser, error) {
+	resp, err := g.opener(newDownloadConfig(opts))(ctx, info.DownloadURL, 0, "")
+	if err != nil {
+		return nil, fmt.Errorf("download request failed: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("download failed with status %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// ErrDownloadMismatch is returned by VerifyDownload when a file does not match its library item
+var ErrDownloadMismatch = errors.New("downloaded file does not match the library item")
+
+// IsEditedDownload reports whether info downloads an edited version, whose hash and size
+// legitimately differ from the item's dedup key and FileSize
+func IsEditedDownload(info *DownloadInfo) bool {
+	return info.IsEdited && info.DownloadURL == info.EditedURL && info.DownloadURL != info.OriginalURL
+}
+
+// CanVerifyDownload reports whether a download can be checked against the item's dedup key.
+// Edited versions and items stored at reduced quality differ from the uploaded file.
+func CanVerifyDownload(info *DownloadInfo) bool {
+	reduced := info.QualityPercentage > 0 && info.QualityPercentage < 100
+	return !IsEditedDownload(info) && !reduced
+}
+
+// VerifyDownload checks the SHA1 of a downloaded original against the item's dedup key.
+// expected is the item's SHA1 when already known (from a dedup key or local file), otherwise
+// the hash is looked up in the library and must resolve to mediaKey. Returns an error wrapping
+// ErrDownloadMismatch on mismatch. Downloads that cannot be verified (see CanVerifyDownload)
+// return false, nil.
+func (g *GooglePhotosAPI) VerifyDownload(ctx context.Context, mediaKey string, info *DownloadInfo, sha1Hash, expected []byte) (bool, error) {
+	if !CanVerifyDownload(info) {
+		return false, nil
+	}
+	if expected != nil {
+		if !bytes.Equal(sha1Hash, expected) {
+			return false, fmt.Errorf("%w: expected dedup key %s, got %s", ErrDownloadMismatch, core.SHA1ToDedupeKey(expected), core.SHA1ToDedupeKey(sha1Hash))
+		}
+		return true, nil
+	}
+
+	found, err := g.FindMediaKeyByHash(ctx, sha1Hash)
+	if err != nil {
+		return false, fmt.Errorf("failed to verify download: %w", err)
+	}
+	if found != mediaKey {
+		return false, fmt.Errorf("%w: dedup key %s does not belong to %s", ErrDownloadMismatch, core.SHA1ToDedupeKey(sha1Hash), mediaKey)
+	}
+	return true, nil
+}
+
+// openFunc starts a GET of a download URL, from offset bytes in when offset > 0.
+// ifRange, if set, is sent as If-Range so a changed resource is sent in full.
+type openFunc func(ctx context.Context, downloadURL string, offset int64, ifRange string) (*http.Response, error)
+
+// openDefault opens downloads with the default HTTP client, without the API client's
+// proxy or retries (used by DownloadFile)
+func openDefault(ctx context.Context, downloadURL string, offset int64, ifRange string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if ifRange != "" {
+			req.Header.Set("If-Range", ifRange)
+		}
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// opener returns an openFunc that opens downloads through the API client, so they use its
+// proxy and retries, with the request settings of cfg
+func (g *GooglePhotosAPI) opener(cfg downloadConfig) openFunc {
+	return func(ctx context.Context, downloadURL string, offset int64, ifRange string) (*http.Response, error) {
+		var requestOpts []core.RequestOption
+		if cfg.auth {
+			requestOpts = append(requestOpts, core.WithAuth())
+		}
+		headers := maps.Clone(cfg.headers)
+		if offset > 0 && ifRange != "" {
+			if headers == nil {
+				headers = make(map[string]string, 1)
+			}
+			headers["If-Range"] = ifRange
+		}
+		if len(headers) > 0 {
+			requestOpts = append(requestOpts, core.WithHeaders(headers))
+		}
+		return g.OpenDownload(ctx, downloadURL, offset, requestOpts...)
+	}
+}
+
+// downloadID identifies the content of a download across runs: the item's media key and
+// version, or the URL when the media key is not known
+func downloadID(info *DownloadInfo) string {
+	if info.MediaKey == "" {
+		return info.DownloadURL
+	}
+	if IsEditedDownload(info) {
+		return info.MediaKey + ":edited"
+	}
+	return info.MediaKey + ":original"
+}
+
+// partState records the download a .part file belongs to
+type partState struct {
+	ID        string `json:"id"`                  // See downloadID
+	Validator string `json:"validator,omitempty"` // Strong ETag or Last-Modified of the response, sent as If-Range
+}
+
+// responseValidator returns the validator of resp usable with If-Range, "" if it has none
+func responseValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// writePartState records what the part at partPath belongs to
+func writePartState(partPath string, state partState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(partPath+partStateSuffix, data, 0644)
+}
+
+// removePart removes a part file and its state
+func removePart(partPath string) {
+	os.Remove(partPath)
+	os.Remove(partPath + partStateSuffix)
+}
+
+// fetch downloads a URL into partPath, resuming an existing part of the same download
+// (id, see downloadID), and returns the SHA1 of the complete file. size is the expected
+// file size, 0 if unknown.
+func fetch(ctx context.Context, open openFunc, downloadURL, partPath, id string, size int64) ([]byte, error) {
+	offset, validator := resumePoint(partPath, id, size)
+	resp, err := open(ctx, downloadURL, offset, validator)
+	if err != nil {
+		return nil, fmt.Errorf("download request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		if offset == size {
+			// The previous attempt got every byte but stopped before renaming
+			hash, err := CalculateSHA1(ctx, partPath)
+			if err == nil {
+				os.Remove(partPath + partStateSuffix)
+			}
+			return hash, err
+		}
+		removePart(partPath)
+		return fetch(ctx, open, downloadURL, partPath, id, size)
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		var start int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-", &start); err != nil || start != offset {
+			return nil, fmt.Errorf("server resumed at an unexpected offset (Content-Range %q)", resp.Header.Get("Content-Range"))
+		}
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		offset = 0 // Range not supported or the resource changed, start over
+	default:
+		return nil, fmt.Errorf("download failed with status %d", resp.StatusCode)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(partPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	if offset == 0 {
+		if err := writePartState(partPath, partState{ID: id, Validator: responseValidator(resp)}); err != nil {
+			return nil, fmt.Errorf("failed to create output file: %w", err)
+		}
+	}
+	flags := os.O_CREATE | os.O_RDWR
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+
+	// Hash the resumed part first, leaving the file positioned at its end
+	hasher := sha1.New()
+	if _, err := io.CopyN(hasher, file, offset); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read %s: %w", partPath, err)
+	}
+	written, err := io.Copy(io.MultiWriter(file, hasher), resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	total := offset + written
+	if err != nil {
+		return nil, fmt.Errorf("download interrupted after %d bytes, run again to resume: %w", total, err)
+	}
+
+	if size > 0 && total != size {
+		if total > size {
+			removePart(partPath)
+		}
+		return nil, fmt.Errorf("size mismatch: got %d bytes, expected %d", total, size)
+	}
+	// The part is complete, it is not resumed any more
+	os.Remove(partPath + partStateSuffix)
+	return hasher.Sum(nil), nil
+}
+
+// resumePoint returns the length of an existing .part file to resume from and the
+// validator to send with the range request. A part of another download, one without a
+// state, or one larger than the expected size cannot be resumed and is removed.
+func resumePoint(partPath, id string, size int64) (int64, string) {
+	info, err := os.Stat(partPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return 0, ""
+	}
+	var state partState
+	data, err := os.ReadFile(partPath + partStateSuffix)
+	if err != nil || json.Unmarshal(data, &state) != nil || state.ID != id || (size > 0 && info.Size() > size) {
+		removePart(partPath)
+		return 0, ""
+	}
+	return info.Size(), state.Validator
+}
@@ -0,0 +1,278 @@
+package gpm
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// rangeServer serves content with Range and If-Range support, recording the Range headers
+func rangeServer(t *testing.T, etag string, content []byte, ranges *[]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*ranges = append(*ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "photo.jpg", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetchResume(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	want := sha1.Sum(content)
+
+	tests := []struct {
+		name      string
+		part      []byte
+		state     *partState // nil writes no state
+		etag      string
+		wantRange string
+	}{
+		{"same download", content[:400], &partState{ID: "key:original", Validator: `"v1"`}, `"v1"`, "bytes=400-"},
+		{"changed resource", bytes.Repeat([]byte("x"), 400), &partState{ID: "key:original", Validator: `"v1"`}, `"v2"`, "bytes=400-"},
+		{"other download", bytes.Repeat([]byte("x"), 400), &partState{ID: "other:original", Validator: `"v1"`}, `"v1"`, ""},
+		{"no state", bytes.Repeat([]byte("x"), 400), nil, `"v1"`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ranges []string
+			srv := rangeServer(t, tt.etag, content, &ranges)
+			partPath := filepath.Join(t.TempDir(), "photo.jpg"+partSuffix)
+			os.WriteFile(partPath, tt.part, 0o644)
+			if tt.state != nil {
+				writePartState(partPath, *tt.state)
+			}
+
+			hash, err := fetch(context.Background(), openDefault, srv.URL, partPath, "key:original", int64(len(content)))
+			if err != nil {
+				t.Fatalf("fetch: %v", err)
+			}
+			got, _ := os.ReadFile(partPath)
+			if !bytes.Equal(hash, want[:]) || !bytes.Equal(got, content) {
+				t.Fatal("downloaded content does not match")
+			}
+			if len(ranges) != 1 || ranges[0] != tt.wantRange {
+				t.Errorf("Range headers %q, want [%q]", ranges, tt.wantRange)
+			}
+			if _, err := os.Stat(partPath + partStateSuffix); !os.IsNotExist(err) {
+				t.Error("state of a complete part was kept")
+			}
+		})
+	}
+}
+
+func TestOpenerHeaders(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.WriteHeader(http.StatusPartialContent)
+	}))
+	defer srv.Close()
+	api, err := NewGooglePhotosAPI(ApiConfig{AuthData: "androidId=1&Token=x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	open := api.opener(newDownloadConfig(DownloadOptions{Headers: map[string]string{"X-Test": "1"}}.downloadOptions()))
+	resp, err := open(context.Background(), srv.URL, 10, `"v1"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got.Get("X-Test") != "1" || got.Get("Range") != "bytes=10-" || got.Get("If-Range") != `"v1"` {
+		t.Errorf("request headers %v, want X-Test, Range and If-Range", got)
+	}
+	if got.Get("Authorization") != "" {
+		t.Error("auth header sent without WithDownloadAuth")
+	}
+}
+
+func TestSaveVerifiesBeforeReplacing(t *testing.T) {
+	content := []byte("downloaded data")
+	var ranges []string
+	srv := rangeServer(t, `"v1"`, content, &ranges)
+	api, err := NewGooglePhotosAPI(ApiConfig{AuthData: "androidId=1&Token=x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	os.WriteFile(path, []byte("good copy"), 0o644)
+
+	info := &DownloadInfo{MediaKey: "key", DownloadURL: srv.URL, FileSize: int64(len(content))}
+	target := saveTarget{pathFor: func(string) string { return path }}
+	other := sha1.Sum([]byte("other data"))
+	_, err = api.save(context.Background(), downloadConfig{verify: true}, info, target, other[:], ConflictOverwrite, nil)
+	if !errors.Is(err, ErrDownloadMismatch) {
+		t.Fatalf("save = %v, want ErrDownloadMismatch", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "good copy" {
+		t.Errorf("existing file was replaced by a mismatching download: %q", got)
+	}
+	if _, err := os.Stat(path + partSuffix); !os.IsNotExist(err) {
+		t.Error("mismatching part was kept")
+	}
+
+	want := sha1.Sum(content)
+	saved, err := api.save(context.Background(), downloadConfig{verify: true}, info, target, want[:], ConflictOverwrite, nil)
+	if err != nil || !saved.Verified {
+		t.Fatalf("save = %+v, %v; want a verified download", saved, err)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, content) {
+		t.Errorf("saved %q, want the download", got)
+	}
+}
+
+func TestCanVerifyDownload(t *testing.T) {
+	tests := []struct {
+		name string
+		info DownloadInfo
+		want bool
+	}{
+		{"original", DownloadInfo{DownloadURL: "o", OriginalURL: "o"}, true},
+		{"quality not reported", DownloadInfo{DownloadURL: "o", OriginalURL: "o", QualityPercentage: 0}, true},
+		{"full quality", DownloadInfo{DownloadURL: "o", OriginalURL: "o", QualityPercentage: 100}, true},
+		{"storage saver", DownloadInfo{DownloadURL: "o", OriginalURL: "o", QualityPercentage: 70}, false},
+		{"edited", DownloadInfo{IsEdited: true, DownloadURL: "e", OriginalURL: "o", EditedURL: "e"}, false},
+	}
+	for _, tt := range tests {
+		if got := CanVerifyDownload(&tt.info); got != tt.want {
+			t.Errorf("%s: CanVerifyDownload = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadItems(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("content of "+r.URL.Path))
+	}))
+	defer srv.Close()
+	api, err := NewGooglePhotosAPI(ApiConfig{AuthData: "androidId=1&Token=x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := func(_ context.Context, input string) (string, []byte, *DownloadInfo, error) {
+		switch input {
+		case "unknown":
+			return "", nil, nil, errors.New("media not found")
+		case "noinfo":
+			return "key-noinfo", nil, nil, errors.New("failed to get download info")
+		}
+		url := srv.URL + "/" + input
+		return "key-" + input, nil, &DownloadInfo{Filename: input + ".jpg", DownloadURL: url, OriginalURL: url}, nil
+	}
+
+	dir := t.TempDir()
+	inputs := []string{"a", "unknown", "b", "gone", "noinfo", "c"}
+	events := api.downloadItems(context.Background(), inputs, DownloadOptions{Workers: 2, OutputDir: dir}, lookup)
+
+	statuses := make(map[string][]DownloadStatus)
+	final := make(map[string]DownloadEvent)
+	for event := range events {
+		if event.Total > 0 {
+			if event.Total != len(inputs) || len(statuses) > 0 {
+				t.Fatalf("total event %d after %d items, want %d first", event.Total, len(statuses), len(inputs))
+			}
+			continue
+		}
+		statuses[event.Input] = append(statuses[event.Input], event.Status)
+		final[event.Input] = event
+	}
+
+	// Each item is reported on its own, and failures do not stop the others
+	ok := []DownloadStatus{DownloadStatusResolving, DownloadStatusDownloading, DownloadStatusCompleted}
+	failedLookup := []DownloadStatus{DownloadStatusResolving, DownloadStatusFailed}
+	failedDownload := []DownloadStatus{DownloadStatusResolving, DownloadStatusDownloading, DownloadStatusFailed}
+	want := map[string][]DownloadStatus{
+		"a": ok, "b": ok, "c": ok,
+		"unknown": failedLookup, "noinfo": failedLookup,
+		"gone": failedDownload,
+	}
+	for input, w := range want {
+		if !slices.Equal(statuses[input], w) {
+			t.Errorf("%s: events %v, want %v", input, statuses[input], w)
+		}
+	}
+	for _, input := range []string{"a", "b", "c"} {
+		event := final[input]
+		got, err := os.ReadFile(event.Path)
+		if err != nil || string(got) != "content of /"+input || event.MediaKey != "key-"+input {
+			t.Errorf("%s: saved %q to %s (%v), want its content", input, got, event.Path, err)
+		}
+	}
+	if final["noinfo"].MediaKey != "key-noinfo" || final["noinfo"].Error == nil {
+		t.Errorf("noinfo: final event %+v, want the media key and error", final["noinfo"])
+	}
+}
+
+func TestDownloadItemsSameItemStaging(t *testing.T) {
+	content := bytes.Repeat([]byte("photo data "), 1000)
+	dir := t.TempDir()
+	// waitFor polls until a file in dir matching pattern has at least size bytes
+	waitFor := func(pattern string, size int) {
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+			for _, match := range matches {
+				if info, err := os.Stat(match); err == nil && info.Size() >= int64(size) {
+					return
+				}
+			}
+		}
+	}
+
+	// The first download stops halfway until the second has been saved, so both are
+	// staged at once: the second starts once the first has written its half
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Write(content[:len(content)/2])
+			w.(http.Flusher).Flush()
+			waitFor("*.jpg", len(content))
+			w.Write(content[len(content)/2:])
+			return
+		}
+		waitFor(".key*.part", len(content)/2)
+		w.Write(content)
+	}))
+	defer srv.Close()
+	api, err := NewGooglePhotosAPI(ApiConfig{AuthData: "androidId=1&Token=x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := func(context.Context, string) (string, []byte, *DownloadInfo, error) {
+		return "key", nil, &DownloadInfo{Filename: "photo.jpg", DownloadURL: srv.URL, OriginalURL: srv.URL, FileSize: int64(len(content))}, nil
+	}
+
+	opts := DownloadOptions{Workers: 2, OutputDir: dir, OutputTemplate: "{dedup_key}.{ext}"}
+	paths := make(map[string]bool)
+	for event := range api.downloadItems(context.Background(), []string{"photo.jpg", "key"}, opts, lookup) {
+		switch event.Status {
+		case DownloadStatusFailed:
+			t.Fatalf("%s: %v", event.Input, event.Error)
+		case DownloadStatusCompleted:
+			paths[event.Path] = true
+		}
+	}
+	if len(paths) != 2 {
+		t.Fatalf("saved to %v, want two files", paths)
+	}
+	for path := range paths {
+		if got, _ := os.ReadFile(path); !bytes.Equal(got, content) {
+			t.Errorf("%s holds %d bytes, want the %d byte download", path, len(got), len(content))
+		}
+	}
+}
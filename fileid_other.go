@@ -0,0 +1,16 @@
+//go:build !unix
+
+package gpm
+
+import "os"
+
+// fileKey identifies a file across paths and links
+type fileKey struct {
+	dev uint64
+	ino uint64
+}
+
+// fileKeyOf is not available on this platform; the walker falls back to os.SameFile
+func fileKeyOf(info os.FileInfo) (fileKey, bool) {
+	return fileKey{}, false
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package gpm
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileKey identifies a file across paths and links
+type fileKey struct {
+	dev uint64
+	ino uint64
+}
+
+// fileKeyOf returns the device and inode of a file
+func fileKeyOf(info os.FileInfo) (fileKey, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileKey{}, false
+	}
+	return fileKey{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
@@ -27,6 +27,7 @@ func (a *Api) CreateAlbum(ctx context.Context, albumName string, mediaKeys []str
 		hasMedia = pb.AlbumCreationMode_EMPTY
 	}
 
+	profile := a.Profile()
 	requestBody := pb.CreateAlbum{
 		AlbumName: albumName,
 		Timestamp: time.Now().Unix(),
@@ -35,9 +36,9 @@ func (a *Api) CreateAlbum(ctx context.Context, albumName string, mediaKeys []str
 		Field6:    &pb.CreateAlbum_Field6Type{},
 		Field7:    &pb.CreateAlbum_Field7Type{Field1: 3},
 		DeviceInfo: &pb.CreateAlbum_DeviceInfo{
-			Model:             a.Model,
-			Make:              a.Make,
-			AndroidApiVersion: a.AndroidAPIVersion,
+			Model:             profile.Model,
+			Make:              profile.Make,
+			AndroidApiVersion: profile.AndroidAPIVersion,
 		},
 	}
 
@@ -68,14 +69,15 @@ func (a *Api) CreateAlbum(ctx context.Context, albumName string, mediaKeys []str
 
 // AddMediaToAlbum adds media items to an existing album
 func (a *Api) AddMediaToAlbum(ctx context.Context, albumKey string, mediaKeys []string) error {
+	profile := a.Profile()
 	requestBody := pb.AddMediaToAlbum{
 		MediaKeys: mediaKeys,
 		AlbumKey:  albumKey,
 		Field5:    &pb.AddMediaToAlbum_Field5Type{Field1: 2},
 		DeviceInfo: &pb.AddMediaToAlbum_DeviceInfo{
-			Model:             a.Model,
-			Make:              a.Make,
-			AndroidApiVersion: a.AndroidAPIVersion,
+			Model:             profile.Model,
+			Make:              profile.Make,
+			AndroidApiVersion: profile.AndroidAPIVersion,
 		},
 		Timestamp: time.Now().Unix(),
 	}
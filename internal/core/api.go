@@ -2,6 +2,7 @@ package core
 
 import (
 	"bytes"
+	"cmp"
 	"compress/gzip"
 	"context"
 	"errors"
@@ -80,22 +81,37 @@ type ApiConfig struct {
 	Quality    string     // Default quality: "original" or "storage-saver"
 	UseQuota   bool       // If true, uploaded files count against storage quota (default: false)
 	TokenCache TokenCache // Optional: custom token cache (nil = use MemoryTokenCache)
+
+	DeviceProfile       string                   // Device profile name (default: "pixel-xl")
+	StorageSaverProfile string                   // Profile for storage-saver commits (default: "pixel-2")
+	QuotaProfile        string                   // Profile for commits counting against quota (default: "pixel-8")
+	DeviceProfiles      map[string]DeviceProfile // Optional: custom profiles, override built-ins with the same name
 }
 
 // Api represents a Google Photos API client
 type Api struct {
+	// Deprecated: the device fields mirror the client's DeviceProfile when it is created
+	// (and Model after SetModel). Requests no longer read them, so changing them has no
+	// effect; use ApiConfig.DeviceProfile, Profile and DeviceInfo instead.
 	AndroidAPIVersion int64
 	Model             string
 	Make              string
 	ClientVersionCode int64
-	UserAgent         string
-	Language          string
-	AuthData          string
-	Client            *http.Client
-	tokenCache        TokenCache
-	authMu            sync.Mutex // Protects token refresh
-	Quality           string     // Default quality: "original" or "storage-saver"
-	UseQuota          bool       // If true, uploaded files count against storage quota (default: false)
+	UserAgent         string // Deprecated: see AndroidAPIVersion
+
+	Language   string
+	AuthData   string
+	Client     *http.Client
+	tokenCache TokenCache
+	authMu     sync.Mutex // Protects token refresh
+	Quality    string     // Default quality: "original" or "storage-saver"
+	UseQuota   bool       // If true, uploaded files count against storage quota (default: false)
+
+	profileMu           sync.RWMutex // Protects profile (see SetModel)
+	profile             DeviceProfile
+	userAgent           string
+	storageSaverProfile DeviceProfile
+	quotaProfile        DeviceProfile
 }
 
 // NewApi creates a new Google Photos API client with the given configuration
@@ -120,25 +136,36 @@ func NewApi(cfg ApiConfig) (*Api, error) {
 		tokenCache = NewMemoryTokenCache()
 	}
 
+	profile, err := resolveDeviceProfile(cmp.Or(cfg.DeviceProfile, DefaultDeviceProfile), cfg.DeviceProfiles)
+	if err != nil {
+		return nil, err
+	}
+	storageSaverProfile, err := resolveDeviceProfile(cmp.Or(cfg.StorageSaverProfile, DefaultStorageSaverProfile), cfg.DeviceProfiles)
+	if err != nil {
+		return nil, err
+	}
+	quotaProfile, err := resolveDeviceProfile(cmp.Or(cfg.QuotaProfile, DefaultQuotaProfile), cfg.DeviceProfiles)
+	if err != nil {
+		return nil, err
+	}
+
 	api := &Api{
-		AndroidAPIVersion: 28,
-		Model:             "Pixel XL",
-		Make:              "Google",
-		ClientVersionCode: 49029607,
-		Language:          language,
-		AuthData:          strings.TrimSpace(cfg.AuthData),
-		Client:            client,
-		tokenCache:        tokenCache,
-		Quality:           cfg.Quality,
-		UseQuota:          cfg.UseQuota,
-	}
-
-	api.UserAgent = fmt.Sprintf(
-		"com.google.android.apps.photos/%d (Linux; U; Android 9; %s; %s; Build/PQ2A.190205.001; Cronet/127.0.6510.5) (gzip)",
-		api.ClientVersionCode,
-		api.Language,
-		api.Model,
-	)
+		AndroidAPIVersion:   profile.AndroidAPIVersion,
+		Model:               profile.Model,
+		Make:                profile.Make,
+		ClientVersionCode:   profile.ClientVersionCode,
+		UserAgent:           profile.userAgent(language),
+		Language:            language,
+		AuthData:            strings.TrimSpace(cfg.AuthData),
+		Client:              client,
+		tokenCache:          tokenCache,
+		Quality:             cfg.Quality,
+		UseQuota:            cfg.UseQuota,
+		profile:             profile,
+		userAgent:           profile.userAgent(language),
+		storageSaverProfile: storageSaverProfile,
+		quotaProfile:        quotaProfile,
+	}
 
 	return api, nil
 }
@@ -164,6 +191,8 @@ func (a *Api) GetAuthToken() (string, error) {
 
 // refreshAccessToken fetches a new auth token from Google (expensive operation)
 func (a *Api) refreshAccessToken() (authToken string, expiry int64, err error) {
+	profile := a.Profile()
+
 	authDataValues, err := url.ParseQuery(a.AuthData)
 	if err != nil {
 		return "", 0, fmt.Errorf("failed to parse auth data: %w", err)
@@ -191,7 +220,7 @@ func (a *Api) refreshAccessToken() (authToken string, expiry int64, err error) {
 		"Connection":      "Keep-Alive",
 		"Content-Type":    "application/x-www-form-urlencoded",
 		"device":          authRequestData.Get("androidId"),
-		"User-Agent":      "GoogleAuth/1.4 (Pixel XL PQ2A.190205.001); gzip",
+		"User-Agent":      fmt.Sprintf("GoogleAuth/1.4 (%s %s); gzip", profile.Model, profile.BuildID),
 	}
 
 	req, err := http.NewRequest(
@@ -255,24 +284,68 @@ func (a *Api) CommonHeaders() map[string]string {
 		"Accept-Encoding":          "gzip",
 		"Accept-Language":          a.Language,
 		"Content-Type":             "application/x-protobuf",
-		"User-Agent":               a.UserAgent,
+		"User-Agent":               a.userAgentHeader(),
 		"x-goog-ext-173412678-bin": "CgcIAhClARgC",
 		"x-goog-ext-174067345-bin": "CgIIAg==",
 	}
 }
 
+// Profile returns the device profile used for requests
+func (a *Api) Profile() DeviceProfile {
+	a.profileMu.RLock()
+	defer a.profileMu.RUnlock()
+	return a.profile
+}
+
+// userAgentHeader returns the User-Agent header sent with API requests
+func (a *Api) userAgentHeader() string {
+	a.profileMu.RLock()
+	defer a.profileMu.RUnlock()
+	return a.userAgent
+}
+
+// UploadProfile returns the device profile to commit an upload with.
+// The server derives quality and quota accounting from the committing device,
+// so the profile is chosen per request instead of changing the client's profile.
+func (a *Api) UploadProfile(quality string, useQuota bool) DeviceProfile {
+	if useQuota {
+		return a.quotaProfile
+	}
+	if quality == "storage-saver" {
+		return a.storageSaverProfile
+	}
+	return a.Profile()
+}
+
 // DeviceInfo returns the current device model and make info
 func (a *Api) DeviceInfo() (model, make string, apiVersion int64) {
-	return a.Model, a.Make, a.AndroidAPIVersion
+	p := a.Profile()
+	return p.Model, p.Make, p.AndroidAPIVersion
 }
 
-// SetModel updates the device model (used for quality settings)
+// SetModel updates the device model of the client's profile
+//
+// Deprecated: select a profile with ApiConfig.DeviceProfile instead
 func (a *Api) SetModel(model string) {
-	a.Model = model
+	a.profileMu.Lock()
+	defer a.profileMu.Unlock()
+	a.profile.Model = model
+	a.userAgent = a.profile.userAgent(a.Language)
+	a.Model, a.UserAgent = model, a.userAgent
+}
+
+// HTTPError is returned when a request completes with a non-2xx status
+type HTTPError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("request failed with status %d: %s", e.StatusCode, e.Body)
 }
 
 // checkResponse checks if the HTTP response status is successful (2xx).
-// Returns an error with the response body if status is not 2xx.
+// Returns an *HTTPError with the response body if status is not 2xx.
 func checkResponse(resp *http.Response) error {
 	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
 		return nil
@@ -280,11 +353,9 @@ func checkResponse(resp *http.Response) error {
 	// Try to read and decompress the error response
 	body, err := readGzipBody(resp)
 	if err != nil {
-		return fmt.Errorf("request failed with status %d (could not read response: %v)", resp.StatusCode, err)
+		return &HTTPError{StatusCode: resp.StatusCode, Body: fmt.Sprintf("(could not read response: %v)", err)}
 	}
-	// Use errors.New to avoid interpreting % characters in the response body
-	bodyStr := string(body)
-	return fmt.Errorf("request failed with status %d: %w", resp.StatusCode, errors.New(bodyStr))
+	return &HTTPError{StatusCode: resp.StatusCode, Body: string(body)}
 }
 
 // readGzipBody reads the response body, handling gzip decompression if needed.
@@ -329,7 +400,7 @@ func (a *Api) DoRequest(ctx context.Context, url string, body io.Reader, opts ..
 			return nil, nil, fmt.Errorf("failed to get bearer token: %w", err)
 		}
 		allHeaders["Authorization"] = "Bearer " + authToken
-		allHeaders["User-Agent"] = a.UserAgent
+		allHeaders["User-Agent"] = a.userAgentHeader()
 	}
 
 	// Merge custom headers (custom headers override defaults)
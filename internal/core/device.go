@@ -0,0 +1,104 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DeviceProfile describes the Android device the client identifies as
+type DeviceProfile struct {
+	Model             string
+	Make              string
+	AndroidAPIVersion int64
+	AndroidVersion    string // Android release, e.g. "9"
+	BuildID           string // Build ID, e.g. "PQ2A.190205.001"
+	ClientVersionCode int64  // Google Photos app version code
+	// UserAgent template, supports {client_version}, {android_version}, {language}, {model} and {build}
+	UserAgent string
+}
+
+// Default device profile names
+const (
+	DefaultDeviceProfile       = "pixel-xl"
+	DefaultStorageSaverProfile = "pixel-2"
+	DefaultQuotaProfile        = "pixel-8"
+)
+
+const defaultUserAgent = "com.google.android.apps.photos/{client_version} (Linux; U; Android {android_version}; {language}; {model}; Build/{build}; Cronet/127.0.6510.5) (gzip)"
+
+// builtinDeviceProfiles are the profiles known to work with the API.
+// Storage-saver uploads are committed as a Pixel 2 and quota uploads as a Pixel 8,
+// which is how the server decides on quality and quota accounting.
+var builtinDeviceProfiles = map[string]DeviceProfile{
+	"pixel-xl": {
+		Model: "Pixel XL", Make: "Google", AndroidAPIVersion: 28, AndroidVersion: "9",
+		BuildID: "PQ2A.190205.001", ClientVersionCode: 49029607, UserAgent: defaultUserAgent,
+	},
+	"pixel-2": {
+		Model: "Pixel 2", Make: "Google", AndroidAPIVersion: 28, AndroidVersion: "9",
+		BuildID: "PQ2A.190205.001", ClientVersionCode: 49029607, UserAgent: defaultUserAgent,
+	},
+	"pixel-8": {
+		Model: "Pixel 8", Make: "Google", AndroidAPIVersion: 28, AndroidVersion: "9",
+		BuildID: "PQ2A.190205.001", ClientVersionCode: 49029607, UserAgent: defaultUserAgent,
+	},
+}
+
+// BuiltinDeviceProfiles returns a copy of the built-in device profiles by name
+func BuiltinDeviceProfiles() map[string]DeviceProfile {
+	profiles := make(map[string]DeviceProfile, len(builtinDeviceProfiles))
+	for name, p := range builtinDeviceProfiles {
+		profiles[name] = p
+	}
+	return profiles
+}
+
+// resolveDeviceProfile looks up a profile by name in custom profiles, then built-ins.
+// Empty fields of custom profiles are filled from the default profile.
+func resolveDeviceProfile(name string, custom map[string]DeviceProfile) (DeviceProfile, error) {
+	if p, ok := custom[name]; ok {
+		return p.withDefaults(builtinDeviceProfiles[DefaultDeviceProfile]), nil
+	}
+	if p, ok := builtinDeviceProfiles[name]; ok {
+		return p, nil
+	}
+	return DeviceProfile{}, fmt.Errorf("unknown device profile: %s", name)
+}
+
+// withDefaults returns a copy of p with empty fields taken from base
+func (p DeviceProfile) withDefaults(base DeviceProfile) DeviceProfile {
+	if p.Model == "" {
+		p.Model = base.Model
+	}
+	if p.Make == "" {
+		p.Make = base.Make
+	}
+	if p.AndroidAPIVersion == 0 {
+		p.AndroidAPIVersion = base.AndroidAPIVersion
+	}
+	if p.AndroidVersion == "" {
+		p.AndroidVersion = base.AndroidVersion
+	}
+	if p.BuildID == "" {
+		p.BuildID = base.BuildID
+	}
+	if p.ClientVersionCode == 0 {
+		p.ClientVersionCode = base.ClientVersionCode
+	}
+	if p.UserAgent == "" {
+		p.UserAgent = base.UserAgent
+	}
+	return p
+}
+
+// userAgent renders the profile's user agent template
+func (p DeviceProfile) userAgent(language string) string {
+	return strings.NewReplacer(
+		"{client_version}", strconv.FormatInt(p.ClientVersionCode, 10),
+		"{android_version}", p.AndroidVersion,
+		"{language}", language,
+		"{model}", p.Model,
+		"{build}", p.BuildID,
+	).Replace(p.UserAgent)
+}
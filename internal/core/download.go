@@ -3,18 +3,25 @@ package core
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"time"
 
 	"github.com/viperadnan-git/go-gpm/internal/pb"
 )
 
 // DownloadInfo contains download information for a media item
 type DownloadInfo struct {
-	Filename    string
-	FileSize    int64
-	IsEdited    bool
-	DownloadURL string // Preferred URL (OriginalURL if available, otherwise EditedURL)
-	OriginalURL string
-	EditedURL   string
+	MediaKey          string
+	Filename          string
+	FileSize          int64
+	QualityPercentage int64     // Stored quality (0-100, 0 if not reported)
+	CreatedAt         time.Time // Time the photo or video was taken (zero if not reported)
+	UploadedAt        time.Time // Time the item was added to the library
+	MediaType         string    // "photo" or "video" (empty if not reported)
+	IsEdited          bool
+	DownloadURL       string // Preferred URL (OriginalURL if available, otherwise EditedURL)
+	OriginalURL       string
+	EditedURL         string
 }
 
 // GetDownloadInfo gets the download information for a media item
@@ -55,12 +62,25 @@ func (a *Api) GetDownloadInfo(ctx context.Context, mediaKey string) (*DownloadIn
 		return nil, err
 	}
 
-	info := &DownloadInfo{}
+	info := &DownloadInfo{MediaKey: mediaKey}
 
 	if response.GetField1() != nil {
 		if response.GetField1().GetMetadata() != nil {
 			info.Filename = response.GetField1().GetMetadata().GetFilename()
 			info.FileSize = response.GetField1().GetMetadata().GetFileSize()
+			info.QualityPercentage = response.GetField1().GetMetadata().GetQualityPercentage()
+			if ms := response.GetField1().GetMetadata().GetCreatedAt(); ms > 0 {
+				info.CreatedAt = time.UnixMilli(ms)
+			}
+			if ms := response.GetField1().GetMetadata().GetUploadedAt(); ms > 0 {
+				info.UploadedAt = time.UnixMilli(ms)
+			}
+			switch response.GetField1().GetMetadata().GetMediaType() {
+			case 1:
+				info.MediaType = "photo"
+			case 2:
+				info.MediaType = "video"
+			}
 		}
 
 		if response.GetField1().GetUrls() != nil {
@@ -88,3 +108,18 @@ func (a *Api) GetDownloadInfo(ctx context.Context, mediaKey string) (*DownloadIn
 
 	return info, nil
 }
+
+// OpenDownload starts a GET of a download URL through the API client, resuming from
+// offset bytes in when offset > 0. The status is not checked so callers can handle
+// range responses. Caller is responsible for closing the response body.
+func (a *Api) OpenDownload(ctx context.Context, url string, offset int64, opts ...RequestOption) (*http.Response, error) {
+	opts = append([]RequestOption{WithMethod("GET"), WithStreamingResponse()}, opts...)
+	if offset > 0 {
+		opts = append(opts, WithHeaders(map[string]string{"Range": fmt.Sprintf("bytes=%d-", offset)}))
+	}
+	_, resp, err := a.DoRequest(ctx, url, nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
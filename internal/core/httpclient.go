@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"io"
 	"log"
 	"net/http"
@@ -12,6 +13,15 @@ import (
 
 var httpClientLogger *log.Logger
 
+// noRetryKey marks a request context whose requests are sent only once
+type noRetryKey struct{}
+
+// WithoutRetries returns a context whose requests are not retried by the HTTP client,
+// for callers that retry whole operations themselves
+func WithoutRetries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRetryKey{}, true)
+}
+
 // SetHTTPClientLogger sets the logger for the HTTP client
 func SetHTTPClientLogger(logger *log.Logger) {
 	httpClientLogger = logger
@@ -49,7 +59,12 @@ func NewHTTPClientWithProxy(proxyURLStr string) (*http.Client, error) {
 	}
 
 	// Important: Configure the retry policy to retry on connection errors
-	retryClient.CheckRetry = retryablehttp.ErrorPropagatedRetryPolicy
+	retryClient.CheckRetry = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		if ctx.Err() == nil && ctx.Value(noRetryKey{}) != nil {
+			return false, nil
+		}
+		return retryablehttp.ErrorPropagatedRetryPolicy(ctx, resp, err)
+	}
 
 	return retryClient.StandardClient(), nil
 }
@@ -12,6 +12,7 @@ import (
 // actionType specifies the operation: MOVE_TO_TRASH, PERMANENT_DELETE, or RESTORE_FROM_TRASH
 // This is the main function that can be used directly for any trash operation
 func (a *Api) PerformTrashAction(ctx context.Context, itemKeys []string, actionType pb.TrashActionType) error {
+	profile := a.Profile()
 	var field4 int64
 	var field8 *pb.TrashAction_Field8
 	var field9 *pb.TrashAction_Field9
@@ -34,8 +35,8 @@ func (a *Api) PerformTrashAction(ctx context.Context, itemKeys []string, actionT
 		field9 = &pb.TrashAction_Field9{
 			Field1: 5,
 			Field2: &pb.TrashAction_Field9_Field2{
-				Field1: a.ClientVersionCode,
-				Field2: strconv.FormatInt(a.AndroidAPIVersion, 10),
+				Field1: profile.ClientVersionCode,
+				Field2: strconv.FormatInt(profile.AndroidAPIVersion, 10),
 			},
 		}
 
@@ -68,8 +69,8 @@ func (a *Api) PerformTrashAction(ctx context.Context, itemKeys []string, actionT
 		field9 = &pb.TrashAction_Field9{
 			Field1: 5,
 			Field2: &pb.TrashAction_Field9_Field2{
-				Field1: a.ClientVersionCode,
-				Field2: strconv.FormatInt(a.AndroidAPIVersion, 10),
+				Field1: profile.ClientVersionCode,
+				Field2: strconv.FormatInt(profile.AndroidAPIVersion, 10),
 			},
 		}
 	}
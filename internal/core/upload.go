@@ -135,16 +135,11 @@ func (a *Api) CommitUpload(
 	}
 	effectiveUseQuota := useQuota || a.UseQuota
 
-	// Determine model based on quality and quota settings
-	model := a.Model
 	var quality int64 = 3 // original
 	if effectiveQuality == "storage-saver" {
 		quality = 1
-		model = "Pixel 2"
-	}
-	if effectiveUseQuota {
-		model = "Pixel 8"
 	}
+	profile := a.UploadProfile(effectiveQuality, effectiveUseQuota)
 
 	unknownConstant := int64(46000000)
 
@@ -164,9 +159,9 @@ func (a *Api) CommitUpload(
 			Field10: 1,
 		},
 		Field2: &pb.CommitUploadField2Type{
-			Model:             model,
-			Make:              a.Make,
-			AndroidApiVersion: a.AndroidAPIVersion,
+			Model:             profile.Model,
+			Make:              profile.Make,
+			AndroidApiVersion: profile.AndroidAPIVersion,
 		},
 		Field3: []byte{1, 3},
 	}
@@ -0,0 +1,299 @@
+package gpm
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultUploadWorkers = 3
+
+var errJobStopped = errors.New("upload job stopped")
+
+// UploadJob describes a batch of files to upload
+type UploadJob struct {
+	Path     string       // File or directory to upload (filtered by Options)
+	Files    []string     // Explicit files or http(s) URLs to upload (not filtered), used when Path is empty
+	Items    []UploadItem // Files with per-file metadata (not filtered), used when Path and Files are empty
+	Options  UploadOptions
+	Priority int // Jobs with higher priority are dispatched first (default: 0)
+}
+
+// UploadItem is a file with metadata of its own. The metadata is applied to the
+// uploaded item, or to the library item if the file was already uploaded. It is not
+// applied to a StatusDuplicate file, which shares the library item of an earlier file
+// of the job. A path listed more than once is uploaded with its first item.
+type UploadItem struct {
+	Path      string    // File or http(s) URL
+	AlbumKey  string    // Album to add the file to instead of the job's album
+	AlbumName string    // Album created by name for the job if AlbumKey is empty
+	Caption   string    // Overrides Options.Caption
+	DateTime  time.Time // Zero keeps the date of the file
+	Location  *Location // nil keeps the location of the file
+	Favourite bool
+}
+
+// Location is a geographic position in degrees
+type Location struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// UploadProgress is a snapshot of an upload job's progress
+type UploadProgress struct {
+	Total          int // Files counted in the batch, or found so far while they are still being counted
+	Completed      int
+	Skipped        int
+	Duplicate      int
+	Failed         int
+	MetadataFailed int // Uploaded or skipped files whose metadata could not be set, also counted above
+}
+
+// UploadHandle tracks a submitted upload job
+type UploadHandle struct {
+	events   chan UploadEvent
+	done     chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	opts     UploadOptions
+	root     string // Directory the job was started from (for MoveTo)
+	priority int
+	pending  sync.WaitGroup         // Queued and running files, plus the producer
+	hashes   *hashGroups            // Files of the job by content, so duplicates are uploaded once
+	once     sync.Once              // Reports the total, counted while files are already uploading
+	albums   *jobAlbums             // Adds finished files to their albums
+	items    map[string]*UploadItem // Per-file metadata by path (UploadJob.Items)
+	pipeline *pipeline
+	stopped  atomic.Bool // No new files are started
+
+	total, found, completed, skipped, duplicate, failed, metadataFailed atomic.Int64
+}
+
+// Events returns the job's event channel, closed when the job finishes.
+// Callers must drain it, as workers block until events are received.
+func (h *UploadHandle) Events() <-chan UploadEvent {
+	return h.events
+}
+
+// Done returns a channel that is closed when the job finishes
+func (h *UploadHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Cancel stops the job: queued files are dropped and running uploads are aborted
+func (h *UploadHandle) Cancel() {
+	h.cancel()
+}
+
+// Stop gracefully ends the job: no new files are started and queued files are
+// reported as StatusCanceled, while files already being processed run to completion.
+// Directory walking stops, so files not reached yet get no event; they are still
+// included in Progress().Total if the files were counted before the stop.
+func (h *UploadHandle) Stop() {
+	h.stopped.Store(true)
+	h.pipeline.stopJob(h)
+}
+
+// Progress returns the current progress of the job
+func (h *UploadHandle) Progress() UploadProgress {
+	return UploadProgress{
+		Total:          int(max(h.total.Load(), h.found.Load())),
+		Completed:      int(h.completed.Load()),
+		Skipped:        int(h.skipped.Load()),
+		Duplicate:      int(h.duplicate.Load()),
+		Failed:         int(h.failed.Load()),
+		MetadataFailed: int(h.metadataFailed.Load()),
+	}
+}
+
+// emit updates progress and delivers an event (dropped once the job is cancelled)
+func (h *UploadHandle) emit(event UploadEvent) {
+	switch event.Status {
+	case StatusCompleted:
+		h.completed.Add(1)
+	case StatusSkipped:
+		h.skipped.Add(1)
+	case StatusDuplicate:
+		h.duplicate.Add(1)
+	case StatusFailed, StatusVerifyFailed:
+		h.failed.Add(1)
+	case StatusMetadataFailed:
+		h.metadataFailed.Add(1)
+	}
+	select {
+	case h.events <- event:
+	case <-h.ctx.Done():
+	}
+	switch event.Status {
+	case StatusCompleted, StatusSkipped, StatusDuplicate:
+		album := h.albums.forItem(h.items[event.Path])
+		if album == nil || event.MediaKey == "" {
+			break
+		}
+		album.add(albumItem{path: event.Path, mediaKey: event.MediaKey, dedupKey: event.DedupKey})
+	}
+}
+
+// SetUploadWorkers sets the minimum number of workers in the shared upload stage.
+// Each stage also grows to the largest setting of submitted jobs and only shrinks on
+// Close; a job never runs more transfers than its UploadOptions.Workers.
+func (g *GooglePhotosAPI) SetUploadWorkers(n int) {
+	g.pipeline.upload.grow(n)
+}
+
+// Close cancels unfinished upload jobs and waits for the upload workers to stop. Jobs submitted
+// afterwards are cancelled immediately.
+func (g *GooglePhotosAPI) Close() {
+	g.pipeline.close()
+}
+
+// Submit queues an upload job on the shared upload pipeline and returns its handle.
+// Jobs run concurrently; files of higher priority jobs are dispatched first.
+func (g *GooglePhotosAPI) Submit(ctx context.Context, job UploadJob) *UploadHandle {
+	jobCtx, cancel := context.WithCancel(ctx)
+	h := &UploadHandle{
+		events:   make(chan UploadEvent),
+		done:     make(chan struct{}),
+		ctx:      jobCtx,
+		cancel:   cancel,
+		opts:     job.Options,
+		priority: job.Priority,
+		hashes:   newHashGroups(),
+		pipeline: g.pipeline,
+	}
+	h.albums = newJobAlbums(jobCtx, g.Api, job.Options, h.emit)
+
+	if len(job.Items) > 0 && job.Path == "" && len(job.Files) == 0 {
+		h.items = make(map[string]*UploadItem, len(job.Items))
+		for _, item := range job.Items {
+			if _, listed := h.items[item.Path]; listed {
+				continue
+			}
+			h.items[item.Path] = &item
+			job.Files = append(job.Files, item.Path)
+		}
+	}
+
+	// Drop queued files as soon as the job is cancelled
+	if !g.pipeline.addJob(h) {
+		cancel()
+	}
+	context.AfterFunc(jobCtx, func() {
+		g.pipeline.removeJob(h)
+	})
+
+	h.pending.Add(1)
+	go func() {
+		defer h.pending.Done()
+		g.produce(h, job)
+	}()
+
+	go func() {
+		h.pending.Wait()
+		// Add the last files to their albums, even if the job was cancelled
+		ctx, stop := context.WithTimeout(context.WithoutCancel(jobCtx), albumFlushTimeout)
+		h.albums.finish(ctx)
+		stop()
+		cancel()
+		close(h.events)
+		close(h.done)
+	}()
+
+	return h
+}
+
+// produce streams the job's files into the pipeline. Directories are walked while
+// uploading, so only the files waiting in the bounded stage queues are held in memory.
+func (g *GooglePhotosAPI) produce(h *UploadHandle, job UploadJob) {
+	g.pipeline.grow(job.Options)
+
+	if job.Path == "" {
+		h.reportTotal(len(job.Files))
+		for _, path := range job.Files {
+			if h.ctx.Err() != nil || h.stopped.Load() {
+				return
+			}
+			g.pipeline.submit(h, path)
+		}
+		return
+	}
+
+	// Root for preserving the relative tree when moving files
+	h.root = job.Path
+	if info, err := os.Stat(job.Path); err == nil && !info.IsDir() {
+		h.root = filepath.Dir(job.Path)
+	}
+
+	// Count the files in the background: the walk below waits for the pipeline,
+	// so the total is usually known long before the walk finishes
+	countCtx, stopCount := context.WithCancel(h.ctx)
+	defer stopCount()
+	h.pending.Add(1)
+	go func() {
+		defer h.pending.Done()
+		h.countFiles(countCtx, job)
+	}()
+
+	count := 0
+	err := WalkFilesFunc(job.Path, job.Options.walkOptions(), func(path string, err error) error {
+		if h.ctx.Err() != nil {
+			return h.ctx.Err()
+		}
+		if h.stopped.Load() {
+			return errJobStopped
+		}
+		if err != nil {
+			// Entries the walk could not read count as failed
+			count++
+			h.found.Add(1)
+			h.emit(UploadEvent{Path: path, Status: StatusFailed, Error: err})
+			return nil
+		}
+		if job.Options.wantsFile(path) {
+			count++
+			h.found.Add(1)
+			g.pipeline.submit(h, path)
+		}
+		return nil
+	})
+	if err != nil {
+		if h.ctx.Err() == nil && !errors.Is(err, errJobStopped) {
+			h.emit(UploadEvent{Status: StatusFailed, Error: err})
+		}
+		return
+	}
+	h.reportTotal(count)
+}
+
+// countFiles walks the job's directory without uploading and reports the total,
+// counting the entries the upload walk reports as failed too
+func (h *UploadHandle) countFiles(ctx context.Context, job UploadJob) {
+	count := 0
+	err := WalkFilesFunc(job.Path, job.Options.walkOptions(), func(path string, err error) error {
+		if err != nil || job.Options.wantsFile(path) {
+			count++
+		}
+		if h.stopped.Load() {
+			return errJobStopped
+		}
+		return ctx.Err()
+	})
+	if err == nil {
+		h.reportTotal(count)
+	}
+}
+
+// reportTotal sends the job's total file count once, from whichever of the counter
+// and the producer finishes first
+func (h *UploadHandle) reportTotal(n int) {
+	h.once.Do(func() {
+		h.total.Store(int64(n))
+		if n > 0 {
+			h.emit(UploadEvent{Total: n})
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package gpm
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// blockingAPI returns a client whose pipeline completes each file once release is closed,
+// so directory walks wait on the bounded queues as they do for slow uploads
+func blockingAPI(release <-chan struct{}) *GooglePhotosAPI {
+	p := &pipeline{jobs: make(map[*UploadHandle]struct{})}
+	complete := func(_ int, t *uploadTask) {
+		<-release
+		t.send(StatusCompleted, nil)
+		t.finish()
+	}
+	p.hash = newStage(complete, nil)
+	p.check = newStage(complete, nil)
+	p.upload = newStage(complete, nil)
+	p.commit = newStage(complete, nil)
+	return &GooglePhotosAPI{pipeline: p}
+}
+
+// photoTree creates n photos below a new directory and returns it
+func photoTree(t *testing.T, n int) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := make([]string, n)
+	for i := range files {
+		files[i] = filepath.Join(fmt.Sprintf("d%d", i%3), fmt.Sprintf("%03d.jpg", i))
+	}
+	writeTree(t, dir, files...)
+	return dir
+}
+
+// waitTotal reads events until the total is reported
+func waitTotal(t *testing.T, h *UploadHandle) (total, completed int) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-h.Events():
+			if event.Status == StatusCompleted {
+				completed++
+			}
+			if event.Total > 0 {
+				return event.Total, completed
+			}
+		case <-timeout:
+			t.Fatal("total was not reported")
+		}
+	}
+}
+
+func TestSubmitReportsTotalBeforeUploads(t *testing.T) {
+	release := make(chan struct{})
+	g := blockingAPI(release)
+	defer g.Close()
+	dir := photoTree(t, 30)
+
+	h := g.Submit(context.Background(), UploadJob{Path: dir, Options: UploadOptions{Recursive: true, HashWorkers: 1}})
+	total, completed := waitTotal(t, h)
+	if total != 30 || completed != 0 {
+		t.Fatalf("total event = %d after %d uploads, want 30 before any", total, completed)
+	}
+	if got := h.Progress().Total; got != 30 {
+		t.Fatalf("Progress().Total = %d, want 30", got)
+	}
+
+	close(release)
+	for event := range h.Events() {
+		if event.Status == StatusCompleted {
+			completed++
+		}
+	}
+	if completed != 30 {
+		t.Fatalf("completed %d files, want 30", completed)
+	}
+}
+
+func TestStopDuringWalk(t *testing.T) {
+	release := make(chan struct{})
+	g := blockingAPI(release)
+	defer g.Close()
+	dir := photoTree(t, 30)
+
+	h := g.Submit(context.Background(), UploadJob{Path: dir, Options: UploadOptions{Recursive: true, HashWorkers: 1}})
+	waitTotal(t, h)
+	// Stop reports the queued files, so it runs while the events are read
+	go func() {
+		h.Stop()
+		close(release)
+	}()
+
+	var seen int
+	for event := range h.Events() {
+		switch event.Status {
+		case StatusCompleted, StatusCanceled:
+			seen++
+		}
+	}
+	if seen >= 30 {
+		t.Fatalf("%d files reported, want the walk to stop before reaching all 30", seen)
+	}
+	// The total still covers the files the walk never reached
+	if got := h.Progress().Total; got != 30 {
+		t.Fatalf("Progress().Total = %d, want 30", got)
+	}
+}
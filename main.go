@@ -2,7 +2,6 @@ package gpm
 
 import (
 	"context"
-	"sync"
 
 	"github.com/viperadnan-git/go-gpm/internal/core"
 )
@@ -19,15 +18,26 @@ type MemoryTokenCache = core.MemoryTokenCache
 // DownloadInfo contains download information for a media item
 type DownloadInfo = core.DownloadInfo
 
+// DeviceProfile describes the Android device the client identifies as
+type DeviceProfile = core.DeviceProfile
+
+// HTTPError is returned when an API request completes with a non-2xx status
+type HTTPError = core.HTTPError
+
 // NewMemoryTokenCache creates a new in-memory token cache
 func NewMemoryTokenCache() *MemoryTokenCache {
 	return core.NewMemoryTokenCache()
 }
 
+// BuiltinDeviceProfiles returns the built-in device profiles by name
+func BuiltinDeviceProfiles() map[string]DeviceProfile {
+	return core.BuiltinDeviceProfiles()
+}
+
 // GooglePhotosAPI is the main API client for Google Photos operations
 type GooglePhotosAPI struct {
 	*core.Api
-	uploadMu sync.Mutex // Serializes upload batches
+	pipeline *pipeline // Shared by all upload jobs
 }
 
 // NewGooglePhotosAPI creates a new Google Photos API client
@@ -36,7 +46,7 @@ func NewGooglePhotosAPI(cfg ApiConfig) (*GooglePhotosAPI, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &GooglePhotosAPI{Api: coreApi}, nil
+	return &GooglePhotosAPI{Api: coreApi, pipeline: newPipeline(coreApi)}, nil
 }
 
 // DownloadThumbnail downloads a thumbnail to the specified output path
@@ -59,5 +69,6 @@ func (g *GooglePhotosAPI) DownloadMedia(ctx context.Context, mediaKey string, ou
 	if err != nil {
 		return "", err
 	}
-	return DownloadFile(info.DownloadURL, outputPath, info.Filename)
+	saved, err := g.SaveDownload(ctx, info, outputPath, ConflictOverwrite)
+	return saved.Path, err
 }
@@ -0,0 +1,459 @@
+package gpm
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/viperadnan-git/go-gpm/internal/core"
+	"github.com/viperadnan-git/go-gpm/internal/pb"
+)
+
+// Default hashing concurrency (checks, uploads and commits default to defaultUploadWorkers)
+const defaultHashWorkers = 2
+
+// uploadTask is a single file moving through the upload pipeline
+type uploadTask struct {
+	job   *UploadHandle
+	path  string
+	seq   uint64 // Submission order, keeps FIFO within a priority
+	start time.Time
+
+	workerID    int // Worker of the current stage
+	src         *uploadSource
+	dedupKey    string
+	commitToken *pb.CommitToken
+	mediaKey    string
+	attempt     int
+}
+
+// emit delivers an event for the task, filling in the per-file fields
+func (t *uploadTask) emit(event UploadEvent) {
+	event.Path, event.WorkerID = t.path, t.workerID
+	event.Duration = time.Since(t.start)
+	if t.src != nil {
+		event.Bytes = t.src.size
+		if t.src.transformed {
+			event.TransformedPath = t.src.localPath
+			event.OriginalDedupKey = core.SHA1ToDedupeKey(t.src.originalSHA1)
+		}
+	}
+	t.job.emit(event)
+}
+
+// send emits a status with the task's known keys
+func (t *uploadTask) send(status UploadStatus, err error) {
+	t.emit(UploadEvent{Status: status, MediaKey: t.mediaKey, DedupKey: t.dedupKey, Error: err})
+}
+
+// finish releases the task's temporary files and marks it done in its job
+func (t *uploadTask) finish() {
+	if t.src != nil {
+		t.src.cleanup()
+	}
+	t.job.pending.Done()
+}
+
+// pipeline moves files through hashing, existence checks, transfer and commit.
+// Each stage has its own workers and priority queue, shared by all jobs; the queues
+// are bounded so fast stages (and directory walks) wait for slow ones. Stages grow
+// to the largest worker settings of submitted jobs, and each job is limited to its
+// own settings.
+type pipeline struct {
+	api    *core.Api
+	seq    atomic.Uint64
+	hash   *stage
+	check  *stage
+	upload *stage
+	commit *stage
+
+	mu     sync.Mutex
+	jobs   map[*UploadHandle]struct{} // Unfinished jobs, cancelled on close
+	closed bool
+}
+
+func newPipeline(api *core.Api) *pipeline {
+	p := &pipeline{api: api, jobs: make(map[*UploadHandle]struct{})}
+	p.hash = newStage(p.hashFile, func(job *UploadHandle) int { return job.opts.hashWorkers() })
+	p.check = newStage(p.checkFile, func(job *UploadHandle) int { return job.opts.checkWorkers() })
+	p.upload = newStage(p.uploadFile, func(job *UploadHandle) int { return job.opts.uploadWorkers() })
+	p.commit = newStage(p.commitFile, func(job *UploadHandle) int { return job.opts.commitWorkers() })
+	return p
+}
+
+func (p *pipeline) stages() []*stage {
+	return []*stage{p.hash, p.check, p.upload, p.commit}
+}
+
+// grow sizes the stages for a job's options
+func (p *pipeline) grow(opts UploadOptions) {
+	p.hash.grow(opts.hashWorkers())
+	p.check.grow(opts.checkWorkers())
+	p.upload.grow(opts.uploadWorkers())
+	p.commit.grow(opts.commitWorkers())
+}
+
+// addJob registers a job until removeJob, and reports false if the pipeline is closed
+func (p *pipeline) addJob(job *UploadHandle) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	p.jobs[job] = struct{}{}
+	return true
+}
+
+// close cancels the unfinished jobs and stops the workers of all stages
+func (p *pipeline) close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	jobs := p.jobs
+	p.jobs = nil
+	p.mu.Unlock()
+
+	for job := range jobs {
+		job.cancel()
+	}
+	for _, s := range p.stages() {
+		s.close()
+	}
+	for _, s := range p.stages() {
+		s.wait()
+	}
+}
+
+// hashWorkers returns the job's hashing concurrency
+func (opts UploadOptions) hashWorkers() int {
+	return workersOr(opts.HashWorkers, defaultHashWorkers)
+}
+
+// checkWorkers returns the job's existence check concurrency
+func (opts UploadOptions) checkWorkers() int {
+	return workersOr(opts.CheckWorkers, opts.uploadWorkers())
+}
+
+// uploadWorkers returns the job's transfer concurrency
+func (opts UploadOptions) uploadWorkers() int {
+	return workersOr(opts.Workers, defaultUploadWorkers)
+}
+
+// commitWorkers returns the job's commit concurrency. Commits also apply metadata,
+// verify and clean up, so they keep up with the transfers by default.
+func (opts UploadOptions) commitWorkers() int {
+	return workersOr(opts.CommitWorkers, opts.uploadWorkers())
+}
+
+// workersOr returns n if positive, otherwise def
+func workersOr(n, def int) int {
+	if n > 0 {
+		return n
+	}
+	return def
+}
+
+// submit queues a file of a job for hashing
+func (p *pipeline) submit(job *UploadHandle, path string) {
+	job.pending.Add(1)
+	p.hash.push(&uploadTask{job: job, path: path, seq: p.seq.Add(1), start: time.Now()})
+}
+
+// removeJob drops the queued tasks of a cancelled job from all stages
+func (p *pipeline) removeJob(job *UploadHandle) {
+	p.mu.Lock()
+	delete(p.jobs, job)
+	p.mu.Unlock()
+	for _, s := range p.stages() {
+		for _, t := range s.queue.removeJob(job) {
+			t.finish()
+		}
+	}
+}
+
+// stopJob drops the files of a stopped job that have not started transferring.
+// Uploaded files waiting to be committed are kept.
+func (p *pipeline) stopJob(job *UploadHandle) {
+	for _, s := range []*stage{p.hash, p.check, p.upload} {
+		for _, t := range s.queue.removeJob(job) {
+			p.cancel(t)
+		}
+	}
+}
+
+// cancel reports a file of a stopped job as not processed, along with its duplicates
+func (p *pipeline) cancel(t *uploadTask) {
+	t.send(StatusCanceled, nil)
+	if t.dedupKey != "" {
+		p.resolve(t, "", false, errJobStopped)
+	}
+	t.finish()
+}
+
+// hashFile reads the source, applies transforms and hashes it.
+// Only the first file of a job with given content continues to the network stages;
+// files hashed later with the same content become its duplicates (see hashGroups).
+func (p *pipeline) hashFile(workerID int, t *uploadTask) {
+	h := t.job
+	if h.ctx.Err() != nil {
+		t.finish()
+		return
+	}
+	if h.stopped.Load() {
+		p.cancel(t)
+		return
+	}
+	t.workerID = workerID
+
+	t.send(StatusHashing, nil)
+	src, err := openSource(h.ctx, p.api, t.path, h.opts)
+	if err != nil {
+		t.send(StatusFailed, err)
+		t.finish()
+		return
+	}
+	t.src = src
+	t.dedupKey = core.SHA1ToDedupeKey(src.sha1)
+
+	self := duplicateFile{path: t.path, workerID: workerID, src: *src, start: t.start}
+	canonical, group := h.hashes.claim(t.dedupKey, self)
+	if !canonical {
+		if group != nil {
+			reportDuplicate(h.ctx, p.api, group, self, t.dedupKey, h.root, h.opts, h.emit)
+		}
+		t.finish()
+		return
+	}
+
+	if h.opts.ForceUpload {
+		p.upload.push(t)
+	} else {
+		p.check.push(t)
+	}
+}
+
+// checkFile skips files that are already in the library
+func (p *pipeline) checkFile(workerID int, t *uploadTask) {
+	h := t.job
+	if h.ctx.Err() != nil {
+		t.finish()
+		return
+	}
+	if h.stopped.Load() {
+		p.cancel(t)
+		return
+	}
+	t.workerID = workerID
+
+	t.send(StatusChecking, nil)
+	if mediaKey, _ := p.api.FindMediaKeyByHash(h.ctx, t.src.sha1); mediaKey != "" {
+		t.mediaKey = mediaKey
+		metadataErr := p.applyItem(h, t.path, mediaKey)
+		event := UploadEvent{Status: StatusSkipped, MediaKey: mediaKey, DedupKey: t.dedupKey}
+		event.Removed, event.MovedTo, event.DeleteError = cleanupHost(h.ctx, p.api, t.path, h.root, mediaKey, t.src, false, h.opts)
+		t.emit(event)
+		t.sendMetadataFailed(metadataErr)
+		p.resolve(t, mediaKey, false, nil)
+		t.finish()
+		return
+	}
+	p.upload.push(t)
+}
+
+// uploadFile transfers the file and passes its commit token to the committer
+func (p *pipeline) uploadFile(workerID int, t *uploadTask) {
+	h := t.job
+	if h.ctx.Err() != nil {
+		t.finish()
+		return
+	}
+	if h.stopped.Load() {
+		p.cancel(t)
+		return
+	}
+	t.workerID = workerID
+
+	t.send(StatusUploading, nil)
+	ctx := transferContext(h)
+	sha1Base64 := base64.StdEncoding.EncodeToString(t.src.sha1)
+	token, err := p.api.GetUploadToken(ctx, sha1Base64, t.src.size)
+	if err != nil {
+		p.retryOrFail(t, &UploadError{Op: "upload token", Err: err})
+		return
+	}
+	commitToken, err := p.api.UploadFile(ctx, t.src.localPath, token)
+	if err != nil {
+		p.retryOrFail(t, &UploadError{Op: "upload", Err: err})
+		return
+	}
+	t.commitToken = commitToken
+	p.commit.push(t)
+}
+
+// commitFile commits an uploaded file, then applies post-upload options,
+// verification and the delete policy
+func (p *pipeline) commitFile(workerID int, t *uploadTask) {
+	h := t.job
+	if h.ctx.Err() != nil {
+		t.finish()
+		return
+	}
+	t.workerID = workerID
+	opts := h.opts
+
+	t.send(StatusFinalizing, nil)
+	mediaKey, err := p.api.CommitUpload(transferContext(h), t.commitToken, t.src.name, t.src.sha1, t.src.modTime.Unix(), opts.Quality, opts.UseQuota)
+	if err == nil && mediaKey == "" {
+		err = fmt.Errorf("no media key returned")
+	}
+	if err != nil {
+		p.retryOrFail(t, &UploadError{Op: "commit", Err: err})
+		return
+	}
+	t.mediaKey = mediaKey
+
+	metadataErr := p.applyOptions(h, t.path, mediaKey)
+
+	// Verify server copy before trusting it (and before deleting the local file)
+	if opts.Verify || opts.deleteMode() == DeleteVerified {
+		t.send(StatusVerifying, nil)
+		if err := verifyUpload(h.ctx, p.api, mediaKey, t.src, opts); err != nil {
+			t.send(StatusVerifyFailed, err)
+			p.resolve(t, "", false, err)
+			t.finish()
+			return
+		}
+	}
+
+	event := UploadEvent{Status: StatusCompleted, MediaKey: mediaKey, DedupKey: t.dedupKey}
+	event.Removed, event.MovedTo, event.DeleteError = cleanupHost(h.ctx, p.api, t.path, h.root, mediaKey, t.src, true, opts)
+	t.emit(event)
+	t.sendMetadataFailed(metadataErr)
+	p.resolve(t, mediaKey, true, nil)
+	t.finish()
+}
+
+// applyOptions sets the caption, favourite and archive options on an uploaded file,
+// then its item metadata, and returns what failed
+func (p *pipeline) applyOptions(h *UploadHandle, path, mediaKey string) error {
+	opts := h.opts
+	item := h.items[path]
+	var errs []error
+	if opts.Caption != "" && (item == nil || item.Caption == "") {
+		if err := p.api.SetCaption(h.ctx, mediaKey, opts.Caption); err != nil {
+			errs = append(errs, fmt.Errorf("caption: %w", err))
+		}
+	}
+	if opts.ShouldFavourite {
+		if err := p.api.SetFavourite(h.ctx, mediaKey, true); err != nil {
+			errs = append(errs, fmt.Errorf("favourite: %w", err))
+		}
+	}
+	if opts.ShouldArchive {
+		if err := p.api.SetArchived(h.ctx, []string{mediaKey}, true); err != nil {
+			errs = append(errs, fmt.Errorf("archive: %w", err))
+		}
+	}
+	return errors.Join(append(errs, p.applyItem(h, path, mediaKey))...)
+}
+
+// applyItem sets the metadata of a file's UploadItem, if it has one, and returns what failed
+func (p *pipeline) applyItem(h *UploadHandle, path, mediaKey string) error {
+	item := h.items[path]
+	if item == nil {
+		return nil
+	}
+	var errs []error
+	if item.Caption != "" {
+		if err := p.api.SetCaption(h.ctx, mediaKey, item.Caption); err != nil {
+			errs = append(errs, fmt.Errorf("caption: %w", err))
+		}
+	}
+	if item.Favourite && !h.opts.ShouldFavourite {
+		if err := p.api.SetFavourite(h.ctx, mediaKey, true); err != nil {
+			errs = append(errs, fmt.Errorf("favourite: %w", err))
+		}
+	}
+	if !item.DateTime.IsZero() {
+		if err := p.api.SetDateTime(h.ctx, []string{mediaKey}, item.DateTime); err != nil {
+			errs = append(errs, fmt.Errorf("datetime: %w", err))
+		}
+	}
+	if item.Location != nil {
+		if err := p.api.SetLocation(h.ctx, mediaKey, float32(item.Location.Latitude), float32(item.Location.Longitude)); err != nil {
+			errs = append(errs, fmt.Errorf("location: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sendMetadataFailed reports metadata that could not be set, after the file's final event
+func (t *uploadTask) sendMetadataFailed(err error) {
+	if err != nil {
+		t.send(StatusMetadataFailed, &UploadError{Op: "metadata", Err: err})
+	}
+}
+
+// transferContext returns the context for a job's upload and commit requests.
+// With per-file retries the pipeline retries them, so the HTTP client sends them once.
+func transferContext(h *UploadHandle) context.Context {
+	if h.opts.Retries > 0 {
+		return core.WithoutRetries(h.ctx)
+	}
+	return h.ctx
+}
+
+// retryOrFail sends a file back to the upload stage after a backoff if the error is
+// transient and retries are left (the backoff doubles on each attempt), or fails it
+func (p *pipeline) retryOrFail(t *uploadTask, err error) {
+	h := t.job
+	if t.attempt >= h.opts.Retries || !IsRetryable(err) {
+		p.fail(t, err)
+		return
+	}
+	t.attempt++
+	t.emit(UploadEvent{Status: StatusRetrying, DedupKey: t.dedupKey, Error: err, Attempt: t.attempt})
+
+	backoff := h.opts.RetryBackoff
+	if backoff <= 0 {
+		backoff = 2 * time.Second
+	}
+	backoff <<= t.attempt - 1
+
+	// Whichever comes first of the backoff and the job's cancellation takes the task
+	var taken atomic.Bool
+	stopWatch := context.AfterFunc(h.ctx, func() {
+		if taken.CompareAndSwap(false, true) {
+			p.fail(t, err)
+		}
+	})
+	time.AfterFunc(backoff, func() {
+		if taken.CompareAndSwap(false, true) {
+			stopWatch()
+			p.upload.push(t)
+		}
+	})
+}
+
+// fail reports a failed file and its duplicates
+func (p *pipeline) fail(t *uploadTask, err error) {
+	t.send(StatusFailed, err)
+	p.resolve(t, "", false, err)
+	t.finish()
+}
+
+// resolve records the result of a canonical file and reports the duplicates waiting on it
+func (p *pipeline) resolve(t *uploadTask, mediaKey string, fresh bool, err error) {
+	h := t.job
+	group, duplicates := h.hashes.finish(t.dedupKey, mediaKey, fresh, err)
+	for _, dup := range duplicates {
+		reportDuplicate(h.ctx, p.api, group, dup, t.dedupKey, h.root, h.opts, h.emit)
+	}
+}
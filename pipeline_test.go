@@ -0,0 +1,129 @@
+package gpm
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// retryPipeline returns a pipeline whose upload stage hands retried tasks to the channel
+func retryPipeline(t *testing.T) (*pipeline, <-chan *uploadTask) {
+	t.Helper()
+	pushed := make(chan *uploadTask, 1)
+	p := &pipeline{jobs: make(map[*UploadHandle]struct{})}
+	p.upload = newStage(func(_ int, task *uploadTask) { pushed <- task }, nil)
+	p.upload.grow(1)
+	t.Cleanup(func() {
+		p.upload.close()
+		p.upload.wait()
+	})
+	return p, pushed
+}
+
+// retryJob returns a job with buffered events and one pending task
+func retryJob(opts UploadOptions) (*UploadHandle, *uploadTask) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &UploadHandle{
+		events: make(chan UploadEvent, 8),
+		ctx:    ctx,
+		cancel: cancel,
+		opts:   opts,
+		hashes: newHashGroups(),
+	}
+	// Tasks reach the upload stage hashed and claimed as the canonical file of their content
+	task := &uploadTask{job: h, path: "a.jpg", dedupKey: "key", start: time.Now()}
+	h.hashes.claim(task.dedupKey, duplicateFile{path: task.path})
+	h.pending.Add(1)
+	return h, task
+}
+
+func TestRetryOrFailBackoff(t *testing.T) {
+	p, pushed := retryPipeline(t)
+	h, task := retryJob(UploadOptions{Retries: 2, RetryBackoff: 20 * time.Millisecond})
+	defer h.cancel()
+	transient := &UploadError{Op: "upload", Err: &HTTPError{StatusCode: 503}}
+
+	// The delay doubles on each attempt
+	for attempt, backoff := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond} {
+		start := time.Now()
+		p.retryOrFail(task, transient)
+		if event := <-h.events; event.Status != StatusRetrying || event.Attempt != attempt+1 {
+			t.Fatalf("event = %s attempt %d, want %s attempt %d", event.Status, event.Attempt, StatusRetrying, attempt+1)
+		}
+		select {
+		case got := <-pushed:
+			if elapsed := time.Since(start); elapsed < backoff {
+				t.Fatalf("attempt %d retried after %v, want at least %v", attempt+1, elapsed, backoff)
+			}
+			task = got
+		case <-time.After(time.Second):
+			t.Fatalf("attempt %d was not retried", attempt+1)
+		}
+	}
+
+	// Retries are used up
+	p.retryOrFail(task, transient)
+	if event := <-h.events; event.Status != StatusFailed || event.Error != transient {
+		t.Fatalf("event = %s (%v), want %s", event.Status, event.Error, StatusFailed)
+	}
+	h.pending.Wait()
+}
+
+func TestRetryOrFailPermanent(t *testing.T) {
+	p, pushed := retryPipeline(t)
+	h, task := retryJob(UploadOptions{Retries: 3})
+	defer h.cancel()
+
+	p.retryOrFail(task, &HTTPError{StatusCode: 400})
+	if event := <-h.events; event.Status != StatusFailed {
+		t.Fatalf("event = %s, want %s without retrying", event.Status, StatusFailed)
+	}
+	h.pending.Wait()
+	select {
+	case <-pushed:
+		t.Fatal("permanent error was retried")
+	default:
+	}
+}
+
+func TestRetryOrFailCancel(t *testing.T) {
+	p, pushed := retryPipeline(t)
+	h, task := retryJob(UploadOptions{Retries: 1, RetryBackoff: time.Hour})
+
+	p.retryOrFail(task, &HTTPError{StatusCode: 503})
+	<-h.events // StatusRetrying
+	h.cancel()
+
+	// Cancelling the job ends the backoff and finishes the task without retrying it
+	done := make(chan struct{})
+	go func() {
+		h.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("cancelled task still waiting for its backoff")
+	}
+	select {
+	case <-pushed:
+		t.Fatal("cancelled task was retried")
+	default:
+	}
+}
+
+func TestStageWorkerDefaults(t *testing.T) {
+	opts := UploadOptions{Workers: 6}
+	if got := opts.commitWorkers(); got != 6 {
+		t.Errorf("commitWorkers() = %d, want the upload workers", got)
+	}
+	if got := opts.checkWorkers(); got != 6 {
+		t.Errorf("checkWorkers() = %d, want the upload workers", got)
+	}
+	if got := (UploadOptions{}).commitWorkers(); got != defaultUploadWorkers {
+		t.Errorf("default commitWorkers() = %d, want %d", got, defaultUploadWorkers)
+	}
+	if got := (UploadOptions{Workers: 6, CommitWorkers: 2}).commitWorkers(); got != 2 {
+		t.Errorf("commitWorkers() = %d, want the explicit 2", got)
+	}
+}
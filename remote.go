@@ -0,0 +1,85 @@
+package gpm
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/viperadnan-git/go-gpm/internal/core"
+)
+
+// IsURL reports whether s is an http(s) URL that can be uploaded directly
+func IsURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// UploadURL streams a remote http(s) object into Google Photos and returns a channel for status events.
+// The object is spooled to a temporary file (in opts.TempDir) while hashing, and removed afterwards.
+func (g *GooglePhotosAPI) UploadURL(ctx context.Context, rawURL string, opts UploadOptions) <-chan UploadEvent {
+	return g.Submit(ctx, UploadJob{Files: []string{rawURL}, Options: opts}).Events()
+}
+
+// spoolURL downloads a URL through the API client into a temporary file, hashing it on the way
+func spoolURL(ctx context.Context, api *core.Api, rawURL, tempDir string) (*uploadSource, error) {
+	_, resp, err := api.DoRequest(
+		ctx,
+		rawURL,
+		nil,
+		core.WithMethod("GET"),
+		core.WithStatusCheck(),
+		core.WithStreamingResponse(),
+	)
+	if err != nil {
+		return nil, &UploadError{Op: "fetch", Err: err}
+	}
+	defer resp.Body.Close()
+
+	filename := extractFilenameFromContentDisposition(resp.Header.Get("Content-Disposition"))
+	if filename == "" {
+		filename = extractFilenameFromURL(resp.Request.URL.String())
+	}
+	filename = filepath.Base(filename)
+	if filename == "" || filename == "." || filename == string(filepath.Separator) {
+		filename = "download"
+	}
+
+	// Keep the extension so transforms and external tools recognize the format
+	spool, err := os.CreateTemp(tempDir, "gpm-spool-*"+filepath.Ext(filename))
+	if err != nil {
+		return nil, &UploadError{Op: "fetch", Err: fmt.Errorf("failed to create spool file: %w", err)}
+	}
+	cleanup := func() { os.Remove(spool.Name()) }
+
+	hash := sha1.New()
+	cw := &chunkedContextWriter{ctx: ctx, w: io.MultiWriter(spool, hash)}
+	buf := make([]byte, copyBufferSize)
+	size, err := io.CopyBuffer(cw, resp.Body, buf)
+	if closeErr := spool.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return nil, &UploadError{Op: "fetch", Err: err}
+	}
+
+	modTime := time.Now()
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		modTime = lastModified
+	}
+
+	return &uploadSource{
+		localPath: spool.Name(),
+		name:      filename,
+		size:      size,
+		modTime:   modTime,
+		sha1:      hash.Sum(nil),
+		remote:    true,
+		cleanup:   cleanup,
+	}, nil
+}
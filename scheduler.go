@@ -0,0 +1,211 @@
+package gpm
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// taskHeap orders tasks by job priority (highest first), then submission order
+type taskHeap []*uploadTask
+
+func (h taskHeap) Len() int { return len(h) }
+func (h taskHeap) Less(i, j int) bool {
+	if h[i].job.priority != h[j].job.priority {
+		return h[i].job.priority > h[j].job.priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h taskHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *taskHeap) Push(x any)   { *h = append(*h, x.(*uploadTask)) }
+func (h *taskHeap) Pop() any {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return t
+}
+
+// taskQueue is a blocking priority queue shared by all upload jobs.
+// push blocks while the queue holds limit() tasks of the same or higher priority, so a
+// bulk job's backlog does not hold back higher priorities, and pop skips jobs that
+// already run jobLimit(job) tasks of the queue's stage.
+type taskQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	tasks    taskHeap
+	limit    func() int                  // Capacity
+	jobLimit func(job *UploadHandle) int // Concurrent tasks per job (nil = no limit)
+	running  map[*UploadHandle]int       // Popped tasks per job, until done
+	closed   bool
+}
+
+func newTaskQueue(limit func() int, jobLimit func(job *UploadHandle) int) *taskQueue {
+	q := &taskQueue{limit: limit, jobLimit: jobLimit, running: make(map[*UploadHandle]int)}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push adds a task to the queue, waiting for room at its priority.
+// Tasks pushed after close are finished without running.
+func (q *taskQueue) push(t *uploadTask) {
+	q.mu.Lock()
+	for q.queuedAtLeast(t.job.priority) >= q.limit() && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		q.mu.Unlock()
+		t.finish()
+		return
+	}
+	heap.Push(&q.tasks, t)
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// queuedAtLeast counts the queued tasks with at least the given priority
+func (q *taskQueue) queuedAtLeast(priority int) int {
+	n := 0
+	for _, t := range q.tasks {
+		if t.job.priority >= priority {
+			n++
+		}
+	}
+	return n
+}
+
+// pop removes the highest priority task of a job below its limit, blocking until
+// one is available. It returns nil once the queue is closed and empty.
+func (q *taskQueue) pop() *uploadTask {
+	q.mu.Lock()
+	for {
+		if i := q.next(); i >= 0 {
+			t := heap.Remove(&q.tasks, i).(*uploadTask)
+			q.running[t.job]++
+			q.mu.Unlock()
+			q.cond.Broadcast()
+			return t
+		}
+		if q.closed && len(q.tasks) == 0 {
+			q.mu.Unlock()
+			return nil
+		}
+		q.cond.Wait()
+	}
+}
+
+// next returns the index of the task pop should run, or -1 if none can run
+func (q *taskQueue) next() int {
+	best := -1
+	for i, t := range q.tasks {
+		if q.jobLimit != nil && q.running[t.job] >= q.jobLimit(t.job) {
+			continue
+		}
+		if best < 0 || q.tasks.Less(i, best) {
+			best = i
+		}
+	}
+	return best
+}
+
+// done marks a popped task of job as finished in the queue's stage
+func (q *taskQueue) done(job *UploadHandle) {
+	q.mu.Lock()
+	if q.running[job]--; q.running[job] <= 0 {
+		delete(q.running, job)
+	}
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// close wakes all waiters: pop drains the remaining tasks, then returns nil
+func (q *taskQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// removeJob drops all queued tasks of a job and returns them
+func (q *taskQueue) removeJob(job *UploadHandle) []*uploadTask {
+	q.mu.Lock()
+	var removed []*uploadTask
+	kept := q.tasks[:0]
+	for _, t := range q.tasks {
+		if t.job != job {
+			kept = append(kept, t)
+		} else {
+			removed = append(removed, t)
+		}
+	}
+	clear(q.tasks[len(kept):])
+	q.tasks = kept
+	heap.Init(&q.tasks)
+	q.mu.Unlock()
+	q.cond.Broadcast()
+	return removed
+}
+
+// stage runs queued tasks on a growable set of workers
+type stage struct {
+	queue   *taskQueue
+	run     func(workerID int, t *uploadTask)
+	mu      sync.Mutex
+	workers int
+	closed  bool
+	running sync.WaitGroup // Started workers, until the stage is closed
+}
+
+// newStage creates a stage. Its queue holds at most twice its worker count,
+// so a slow stage holds back the stages feeding it. A job runs at most
+// jobLimit(job) tasks of the stage at once.
+func newStage(run func(workerID int, t *uploadTask), jobLimit func(job *UploadHandle) int) *stage {
+	s := &stage{run: run}
+	s.queue = newTaskQueue(func() int {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return 2 * max(s.workers, 1)
+	}, jobLimit)
+	return s
+}
+
+// push queues a task for the stage
+func (s *stage) push(t *uploadTask) {
+	s.queue.push(t)
+}
+
+// grow starts workers until the stage has at least n (stages never shrink, and stop on close)
+func (s *stage) grow(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	for ; s.workers < n; s.workers++ {
+		s.running.Add(1)
+		go func(workerID int) {
+			defer s.running.Done()
+			for {
+				t := s.queue.pop()
+				if t == nil {
+					return
+				}
+				job := t.job
+				s.run(workerID, t)
+				s.queue.done(job)
+			}
+		}(s.workers)
+	}
+}
+
+// close stops taking tasks; workers exit once the queued tasks have run
+func (s *stage) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.queue.close()
+}
+
+// wait blocks until the workers of a closed stage have exited
+func (s *stage) wait() {
+	s.running.Wait()
+}
@@ -0,0 +1,157 @@
+package gpm
+
+import (
+	"container/heap"
+	"testing"
+	"time"
+)
+
+func TestTaskHeapOrder(t *testing.T) {
+	low, high := &UploadHandle{priority: 0}, &UploadHandle{priority: 5}
+	tests := []struct {
+		name  string
+		tasks []*uploadTask
+		want  []uint64 // seq in pop order
+	}{
+		{
+			name:  "fifo within a priority",
+			tasks: []*uploadTask{{job: low, seq: 3}, {job: low, seq: 1}, {job: low, seq: 2}},
+			want:  []uint64{1, 2, 3},
+		},
+		{
+			name:  "higher priority first",
+			tasks: []*uploadTask{{job: low, seq: 1}, {job: high, seq: 4}, {job: low, seq: 2}, {job: high, seq: 3}},
+			want:  []uint64{3, 4, 1, 2},
+		},
+		{
+			name:  "negative priority last",
+			tasks: []*uploadTask{{job: &UploadHandle{priority: -1}, seq: 1}, {job: low, seq: 2}},
+			want:  []uint64{2, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h taskHeap
+			for _, task := range tt.tasks {
+				heap.Push(&h, task)
+			}
+			var got []uint64
+			for h.Len() > 0 {
+				got = append(got, heap.Pop(&h).(*uploadTask).seq)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("popped %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("popped %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTaskQueueJobLimit(t *testing.T) {
+	busy := &UploadHandle{priority: 5}
+	other := &UploadHandle{}
+	q := newTaskQueue(func() int { return 10 }, func(*UploadHandle) int { return 1 })
+	q.push(&uploadTask{job: busy, seq: 1})
+	q.push(&uploadTask{job: busy, seq: 2})
+	q.push(&uploadTask{job: other, seq: 3})
+
+	if got := q.pop(); got.seq != 1 {
+		t.Fatalf("first pop = %d, want 1", got.seq)
+	}
+	// busy is at its limit, so the lower priority job runs next
+	if got := q.pop(); got.seq != 3 {
+		t.Fatalf("second pop = %d, want 3", got.seq)
+	}
+
+	popped := make(chan *uploadTask)
+	go func() { popped <- q.pop() }()
+	select {
+	case task := <-popped:
+		t.Fatalf("popped %d while both jobs are at their limit", task.seq)
+	case <-time.After(50 * time.Millisecond):
+	}
+	q.done(busy)
+	if got := <-popped; got.seq != 2 {
+		t.Fatalf("third pop = %d, want 2", got.seq)
+	}
+}
+
+func TestTaskQueueClose(t *testing.T) {
+	job := &UploadHandle{}
+	q := newTaskQueue(func() int { return 10 }, nil)
+	job.pending.Add(2)
+	q.push(&uploadTask{job: job, seq: 1})
+	q.close()
+
+	// Queued tasks are still handed out, new ones are finished without running
+	q.push(&uploadTask{job: job, seq: 2})
+	if got := q.pop(); got == nil || got.seq != 1 {
+		t.Fatalf("pop after close = %v, want the queued task", got)
+	}
+	if got := q.pop(); got != nil {
+		t.Fatalf("pop of a closed, empty queue = %d, want nil", got.seq)
+	}
+	job.pending.Done()
+	job.pending.Wait()
+}
+
+func TestStageClose(t *testing.T) {
+	ran := make(chan uint64, 4)
+	s := newStage(func(_ int, task *uploadTask) { ran <- task.seq }, nil)
+	s.grow(2)
+	s.push(&uploadTask{job: &UploadHandle{}, seq: 1})
+	s.close()
+
+	done := make(chan struct{})
+	go func() {
+		s.wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("workers did not exit after close")
+	}
+	if got := <-ran; got != 1 {
+		t.Fatalf("ran %d, want the queued task 1", got)
+	}
+}
+
+func TestTaskQueuePriorityBypassesBound(t *testing.T) {
+	bulk, urgent := &UploadHandle{}, &UploadHandle{priority: 1}
+	q := newTaskQueue(func() int { return 2 }, nil)
+	q.push(&uploadTask{job: bulk, seq: 1})
+	q.push(&uploadTask{job: bulk, seq: 2})
+
+	pushed := make(chan struct{})
+	go func() {
+		q.push(&uploadTask{job: urgent, seq: 3})
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatal("higher priority push waited behind a full queue of lower priority")
+	}
+
+	// Lower priorities still wait for room
+	bulkPushed := make(chan struct{})
+	go func() {
+		q.push(&uploadTask{job: bulk, seq: 4})
+		close(bulkPushed)
+	}()
+	if got := q.pop(); got.seq != 3 {
+		t.Fatalf("pop = %d, want the higher priority task 3", got.seq)
+	}
+	select {
+	case <-bulkPushed:
+		t.Fatal("lower priority push did not wait for room")
+	case <-time.After(50 * time.Millisecond):
+	}
+	q.pop()
+	<-bulkPushed
+}
@@ -0,0 +1,474 @@
+package gpm
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ScrubTransform removes privacy-sensitive metadata from JPEG, HEIC and PNG files
+// without re-encoding pixels. EXIF entries are cleared in place, so the file layout
+// (and HEIC item offsets) are unchanged. Matching files whose content is in none of
+// these formats are uploaded unchanged with a warning.
+type ScrubTransform struct {
+	GPS    bool // GPS coordinates and XMP location fields
+	Serial bool // Camera and lens serial numbers, owner names and maker notes
+	All    bool // All EXIF (except orientation), XMP and text metadata
+}
+
+// ParseScrubTransform parses a comma-separated list of "gps", "serial" and "all"
+func ParseScrubTransform(spec string) (*ScrubTransform, error) {
+	t := &ScrubTransform{}
+	for _, part := range strings.Split(spec, ",") {
+		switch strings.ToLower(strings.TrimSpace(part)) {
+		case "gps":
+			t.GPS = true
+		case "serial":
+			t.Serial = true
+		case "all":
+			t.All = true
+		case "":
+		default:
+			return nil, fmt.Errorf("invalid metadata category: %s (use gps, serial or all)", part)
+		}
+	}
+	if !t.GPS && !t.Serial && !t.All {
+		return nil, fmt.Errorf("no metadata category given (use gps, serial or all)")
+	}
+	return t, nil
+}
+
+func (t *ScrubTransform) Name() string {
+	return "strip-metadata"
+}
+
+func (t *ScrubTransform) Match(filename string) bool {
+	return matchExtension(filename, []string{"jpg", "jpeg", "heic", "heif", "png"})
+}
+
+func (t *ScrubTransform) Apply(ctx context.Context, input, outputDir string) (string, error) {
+	data, err := os.ReadFile(input)
+	if err != nil {
+		return "", err
+	}
+
+	var out []byte
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		out, err = t.scrubJPEG(data)
+	case bytes.HasPrefix(data, pngSignature):
+		out, err = t.scrubPNG(data)
+	case len(data) >= 12 && string(data[4:8]) == "ftyp":
+		out = bytes.Clone(data)
+		err = t.scrubHEIC(out)
+	default:
+		// A misnamed file, e.g. a WebP saved as .jpg: upload it as is rather than fail
+		slog.Warn("metadata not stripped: unrecognized image format", "file", input)
+		return input, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if bytes.Equal(out, data) {
+		return input, nil
+	}
+
+	output := filepath.Join(outputDir, filepath.Base(input))
+	if err := os.WriteFile(output, out, 0o600); err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
+// EXIF tags
+const (
+	tagOrientation  = 0x0112
+	tagExifIFD      = 0x8769
+	tagGPSIFD       = 0x8825
+	tagInteropIFD   = 0xA005
+	tagThumbOffset  = 0x0201
+	tagThumbLength  = 0x0202
+	tagArtist       = 0x013B
+	tagXPAuthor     = 0x9C9D
+	tagMakerNote    = 0x927C
+	tagOwnerName    = 0xA430
+	tagBodySerial   = 0xA431
+	tagLensSerial   = 0xA435
+	tagCameraSerial = 0xC62F // DNG
+	ifdEntrySize    = 12
+	maxIFDChain     = 16
+)
+
+// serialTags identify the camera or its owner
+var serialTags = map[uint16]bool{
+	tagArtist: true, tagXPAuthor: true, tagMakerNote: true, tagOwnerName: true,
+	tagBodySerial: true, tagLensSerial: true, tagCameraSerial: true,
+}
+
+// tiffTypeSizes are the byte sizes of TIFF field types
+var tiffTypeSizes = map[uint16]uint32{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4,
+}
+
+var errInvalidTIFF = errors.New("invalid EXIF data")
+
+// tiffScrubber edits a TIFF (EXIF) structure in place
+type tiffScrubber struct {
+	b       []byte
+	order   binary.ByteOrder
+	visited map[uint32]bool
+}
+
+// scrubTIFF clears the selected metadata from TIFF data in place
+func (t *ScrubTransform) scrubTIFF(b []byte) error {
+	if len(b) < 8 {
+		return errInvalidTIFF
+	}
+	s := &tiffScrubber{b: b, visited: make(map[uint32]bool)}
+	switch string(b[:4]) {
+	case "II*\x00":
+		s.order = binary.LittleEndian
+	case "MM\x00*":
+		s.order = binary.BigEndian
+	default:
+		return errInvalidTIFF
+	}
+	ifd0 := s.order.Uint32(b[4:8])
+	if t.All {
+		return s.clearIFD(ifd0, true, true)
+	}
+	return s.walkIFD(ifd0, t, 0)
+}
+
+// entries returns the entry count and first entry offset of an IFD
+func (s *tiffScrubber) entries(off uint32) (int, uint32, error) {
+	if uint64(off)+2 > uint64(len(s.b)) {
+		return 0, 0, errInvalidTIFF
+	}
+	n := int(s.order.Uint16(s.b[off:]))
+	if uint64(off)+2+uint64(n)*ifdEntrySize+4 > uint64(len(s.b)) {
+		return 0, 0, errInvalidTIFF
+	}
+	return n, off + 2, nil
+}
+
+// walkIFD applies the GPS and serial rules to an IFD, its sub-IFDs and following IFDs
+func (s *tiffScrubber) walkIFD(off uint32, t *ScrubTransform, depth int) error {
+	for ; off != 0 && depth < maxIFDChain; depth++ {
+		if s.visited[off] {
+			return nil
+		}
+		s.visited[off] = true
+		n, first, err := s.entries(off)
+		if err != nil {
+			return err
+		}
+		for i := range n {
+			entry := first + uint32(i)*ifdEntrySize
+			tag := s.order.Uint16(s.b[entry:])
+			switch {
+			case tag == tagGPSIFD && t.GPS:
+				if err := s.clearIFD(s.order.Uint32(s.b[entry+8:]), false, false); err != nil {
+					return err
+				}
+			case tag == tagExifIFD || tag == tagInteropIFD:
+				if err := s.walkIFD(s.order.Uint32(s.b[entry+8:]), t, depth+1); err != nil {
+					return err
+				}
+			case serialTags[tag] && t.Serial:
+				if err := s.zeroValue(entry); err != nil {
+					return err
+				}
+			}
+		}
+		off = s.order.Uint32(s.b[first+uint32(n)*ifdEntrySize:])
+	}
+	return nil
+}
+
+// clearIFD zeroes all entries of an IFD and their data, following sub-IFDs (and the
+// next IFD when next is set). With keepOrientation, the orientation entry survives.
+func (s *tiffScrubber) clearIFD(off uint32, next, keepOrientation bool) error {
+	if off == 0 || s.visited[off] {
+		return nil
+	}
+	s.visited[off] = true
+	n, first, err := s.entries(off)
+	if err != nil {
+		return err
+	}
+	nextOff := s.order.Uint32(s.b[first+uint32(n)*ifdEntrySize:])
+
+	var kept []byte
+	var thumbOffset, thumbLength uint32
+	for i := range n {
+		entry := first + uint32(i)*ifdEntrySize
+		tag := s.order.Uint16(s.b[entry:])
+		switch tag {
+		case tagOrientation:
+			if keepOrientation {
+				kept = bytes.Clone(s.b[entry : entry+ifdEntrySize])
+				continue
+			}
+		case tagExifIFD, tagGPSIFD, tagInteropIFD:
+			if err := s.clearIFD(s.order.Uint32(s.b[entry+8:]), false, false); err != nil {
+				return err
+			}
+		case tagThumbOffset:
+			thumbOffset = s.order.Uint32(s.b[entry+8:])
+		case tagThumbLength:
+			thumbLength = s.order.Uint32(s.b[entry+8:])
+		}
+		if err := s.zeroValue(entry); err != nil {
+			return err
+		}
+	}
+	if thumbLength > 0 && uint64(thumbOffset)+uint64(thumbLength) <= uint64(len(s.b)) {
+		clear(s.b[thumbOffset : thumbOffset+thumbLength])
+	}
+
+	// Zero the entry table and the next-IFD pointer, then keep at most one entry
+	clear(s.b[off : first+uint32(n)*ifdEntrySize+4])
+	if kept != nil {
+		s.order.PutUint16(s.b[off:], 1)
+		copy(s.b[first:], kept)
+	}
+
+	if next {
+		return s.clearIFD(nextOff, true, false)
+	}
+	return nil
+}
+
+// zeroValue zeroes the value of an IFD entry, inline or at its offset
+func (s *tiffScrubber) zeroValue(entry uint32) error {
+	typ := s.order.Uint16(s.b[entry+2:])
+	count := s.order.Uint32(s.b[entry+4:])
+	size := uint64(tiffTypeSizes[typ]) * uint64(count)
+	if size <= 4 {
+		clear(s.b[entry+8 : entry+12])
+		return nil
+	}
+	off := uint64(s.order.Uint32(s.b[entry+8:]))
+	if off+size > uint64(len(s.b)) {
+		return errInvalidTIFF
+	}
+	clear(s.b[off : off+size])
+	return nil
+}
+
+// XMP properties removed per category (qualified with their conventional prefixes)
+var (
+	xmpGPSProperties    = `exif:GPS\w*|photoshop:(?:City|State|Country)|Iptc4xmpCore:Location|Iptc4xmpExt:Location(?:Created|Shown)`
+	xmpSerialProperties = `aux:(?:SerialNumber|LensSerialNumber|OwnerName)|exifEX:(?:BodySerialNumber|LensSerialNumber|CameraOwnerName)`
+)
+
+// scrubXMP blanks the selected XMP properties in place, keeping the packet length
+func (t *ScrubTransform) scrubXMP(b []byte) {
+	var names []string
+	if t.GPS {
+		names = append(names, xmpGPSProperties)
+	}
+	if t.Serial {
+		names = append(names, xmpSerialProperties)
+	}
+	if len(names) == 0 {
+		return
+	}
+	pattern := `(?:` + strings.Join(names, "|") + `)`
+
+	// Attribute form: exif:GPSLatitude="..."
+	attr := regexp.MustCompile(`\s` + pattern + `\s*=\s*(?:"[^"]*"|'[^']*')`)
+	for _, loc := range attr.FindAllIndex(b, -1) {
+		blank(b[loc[0]:loc[1]])
+	}
+
+	// Element form: <exif:GPSLatitude>...</exif:GPSLatitude> or <exif:GPSLatitude/>
+	open := regexp.MustCompile(`<(` + pattern + `)(?:\s[^>]*)?>`)
+	for _, loc := range open.FindAllSubmatchIndex(b, -1) {
+		start, end := loc[0], loc[1]
+		if bytes.Equal(b[end-2:end], []byte("/>")) {
+			blank(b[start:end])
+			continue
+		}
+		closing := []byte("</" + string(b[loc[2]:loc[3]]) + ">")
+		if i := bytes.Index(b[end:], closing); i >= 0 {
+			blank(b[start : end+i+len(closing)])
+		}
+	}
+}
+
+// emptyXMP replaces an XMP packet with an empty one padded to the same length
+func emptyXMP(b []byte) {
+	const empty = `<x:xmpmeta xmlns:x="adobe:ns:meta/"/>`
+	blank(b)
+	if len(b) >= len(empty) {
+		copy(b, empty)
+	}
+}
+
+// blank overwrites b with spaces (valid whitespace in XML)
+func blank(b []byte) {
+	for i := range b {
+		b[i] = ' '
+	}
+}
+
+var (
+	exifHeader        = []byte("Exif\x00\x00")
+	xmpHeader         = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	xmpExtendedHeader = []byte("http://ns.adobe.com/xmp/extension/\x00")
+	pngSignature      = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// scrubJPEG scrubs EXIF and XMP segments. With All, XMP, IPTC (APP13) and comments are dropped.
+func (t *ScrubTransform) scrubJPEG(data []byte) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil, fmt.Errorf("invalid JPEG marker at offset %d", pos)
+		}
+		marker := data[pos+1]
+		// Markers without a length
+		if marker == 0xFF {
+			pos++
+			continue
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out.Write(data[pos : pos+2])
+			pos += 2
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, fmt.Errorf("invalid JPEG segment at offset %d", pos)
+		}
+		// Image data follows start of scan, copy the rest verbatim
+		if marker == 0xDA {
+			out.Write(data[pos:])
+			return out.Bytes(), nil
+		}
+
+		segment := bytes.Clone(data[pos:end])
+		payload := segment[4:]
+		keep := true
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(payload, exifHeader):
+			if err := t.scrubTIFF(payload[len(exifHeader):]); err != nil {
+				return nil, err
+			}
+		case marker == 0xE1 && (bytes.HasPrefix(payload, xmpHeader) || bytes.HasPrefix(payload, xmpExtendedHeader)):
+			keep = !t.All
+			t.scrubXMP(payload)
+		case marker == 0xED || marker == 0xFE: // APP13 (IPTC), COM
+			keep = !t.All
+		}
+		if keep {
+			out.Write(segment)
+		}
+		pos = end
+	}
+	return nil, fmt.Errorf("JPEG has no image data")
+}
+
+// scrubPNG scrubs eXIf and XMP chunks. With All, all text chunks are dropped.
+func (t *ScrubTransform) scrubPNG(data []byte) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(pngSignature)
+	pos := len(pngSignature)
+	for pos+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, fmt.Errorf("invalid PNG chunk at offset %d", pos)
+		}
+		typ := string(data[pos+4 : pos+8])
+		chunk := bytes.Clone(data[pos+8 : pos+8+length])
+		pos = end
+
+		switch typ {
+		case "eXIf":
+			if err := t.scrubTIFF(chunk); err != nil {
+				return nil, err
+			}
+		case "iTXt", "tEXt", "zTXt":
+			if t.All {
+				continue
+			}
+			if typ == "iTXt" && bytes.HasPrefix(chunk, []byte("XML:com.adobe.xmp\x00")) {
+				var err error
+				if chunk, err = t.scrubPNGXMP(chunk); err != nil {
+					return nil, err
+				}
+			}
+		}
+		writePNGChunk(out, typ, chunk)
+		if typ == "IEND" {
+			break
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// scrubPNGXMP scrubs the XMP text of an iTXt chunk, decompressing it if needed
+func (t *ScrubTransform) scrubPNGXMP(chunk []byte) ([]byte, error) {
+	// keyword\0 compression-flag compression-method language\0 translated-keyword\0 text
+	i := bytes.IndexByte(chunk, 0) + 3
+	if i+1 > len(chunk) {
+		return chunk, nil
+	}
+	compressed := chunk[i-2] == 1
+	for range 2 {
+		j := bytes.IndexByte(chunk[i:], 0)
+		if j < 0 {
+			return chunk, nil
+		}
+		i += j + 1
+	}
+	text := chunk[i:]
+	if !compressed {
+		t.scrubXMP(text)
+		return chunk, nil
+	}
+
+	r, err := zlib.NewReader(bytes.NewReader(text))
+	if err != nil {
+		return nil, fmt.Errorf("invalid XMP chunk: %w", err)
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid XMP chunk: %w", err)
+	}
+	t.scrubXMP(plain)
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write(plain)
+	w.Close()
+	return append(chunk[:i:i], buf.Bytes()...), nil
+}
+
+// writePNGChunk writes a chunk with its length and CRC
+func writePNGChunk(w *bytes.Buffer, typ string, data []byte) {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], typ)
+	w.Write(header[:])
+	w.Write(data)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	binary.Write(w, binary.BigEndian, crc.Sum32())
+}
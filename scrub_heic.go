@@ -0,0 +1,289 @@
+package gpm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var errInvalidHEIC = errors.New("invalid HEIC container")
+
+// isoBox is an ISO BMFF box within a buffer
+type isoBox struct {
+	typ   string
+	start int // Offset of the box header
+	data  int // Offset of the box payload
+	end   int
+}
+
+// readBoxes lists the boxes in b[start:end]
+func readBoxes(b []byte, start, end int) ([]isoBox, error) {
+	var boxes []isoBox
+	for pos := start; pos+8 <= end; {
+		size := int64(binary.BigEndian.Uint32(b[pos:]))
+		box := isoBox{typ: string(b[pos+4 : pos+8]), start: pos, data: pos + 8}
+		switch size {
+		case 0: // Extends to the end
+			size = int64(end - pos)
+		case 1: // 64-bit size follows the type
+			if pos+16 > end {
+				return nil, errInvalidHEIC
+			}
+			size = int64(binary.BigEndian.Uint64(b[pos+8:]))
+			box.data = pos + 16
+		}
+		if size < int64(box.data-pos) || int64(pos)+size > int64(end) {
+			return nil, errInvalidHEIC
+		}
+		box.end = pos + int(size)
+		boxes = append(boxes, box)
+		pos = box.end
+	}
+	return boxes, nil
+}
+
+func findBox(boxes []isoBox, typ string) (isoBox, bool) {
+	for _, box := range boxes {
+		if box.typ == typ {
+			return box, true
+		}
+	}
+	return isoBox{}, false
+}
+
+// heicReader reads big-endian fields from a box payload with bounds checks
+type heicReader struct {
+	b   []byte
+	pos int
+	end int
+	err error
+}
+
+func (r *heicReader) uint(size int) uint64 {
+	if r.err != nil || r.pos+size > r.end {
+		r.err = errInvalidHEIC
+		return 0
+	}
+	var v uint64
+	for _, c := range r.b[r.pos : r.pos+size] {
+		v = v<<8 | uint64(c)
+	}
+	r.pos += size
+	return v
+}
+
+func (r *heicReader) cstring() string {
+	start := r.pos
+	for r.err == nil && r.pos < r.end {
+		if r.b[r.pos] == 0 {
+			r.pos++
+			return string(r.b[start : r.pos-1])
+		}
+		r.pos++
+	}
+	r.err = errInvalidHEIC
+	return ""
+}
+
+// scrubHEIC scrubs the Exif and XMP items of a HEIC/HEIF file in place
+func (t *ScrubTransform) scrubHEIC(b []byte) error {
+	top, err := readBoxes(b, 0, len(b))
+	if err != nil {
+		return err
+	}
+	meta, ok := findBox(top, "meta")
+	if !ok {
+		return nil
+	}
+	// meta is a full box: skip version and flags
+	children, err := readBoxes(b, meta.data+4, meta.end)
+	if err != nil {
+		return err
+	}
+
+	exifItems, xmpItems, err := heicMetadataItems(b, children)
+	if err != nil {
+		return err
+	}
+	if len(exifItems) == 0 && len(xmpItems) == 0 {
+		return nil
+	}
+	locations, err := heicItemLocations(b, children)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range exifItems {
+		data, err := heicItemData(b, locations, id)
+		if err != nil {
+			return err
+		}
+		// Exif items start with the offset of the TIFF header
+		if len(data) < 4 {
+			return errInvalidHEIC
+		}
+		offset := 4 + int(binary.BigEndian.Uint32(data))
+		if offset > len(data) {
+			return errInvalidHEIC
+		}
+		if err := t.scrubTIFF(data[offset:]); err != nil {
+			return err
+		}
+	}
+	for _, id := range xmpItems {
+		data, err := heicItemData(b, locations, id)
+		if err != nil {
+			return err
+		}
+		if t.All {
+			emptyXMP(data)
+		} else {
+			t.scrubXMP(data)
+		}
+	}
+	return nil
+}
+
+// heicMetadataItems returns the IDs of Exif and XMP items from the iinf box
+func heicMetadataItems(b []byte, meta []isoBox) (exif, xmp []uint32, err error) {
+	iinf, ok := findBox(meta, "iinf")
+	if !ok {
+		return nil, nil, nil
+	}
+	r := &heicReader{b: b, pos: iinf.data, end: iinf.end}
+	version := r.uint(1)
+	r.uint(3)
+	countSize := 4
+	if version == 0 {
+		countSize = 2
+	}
+	r.uint(countSize)
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+
+	entries, err := readBoxes(b, r.pos, iinf.end)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, infe := range entries {
+		if infe.typ != "infe" {
+			continue
+		}
+		r := &heicReader{b: b, pos: infe.data, end: infe.end}
+		version := r.uint(1)
+		r.uint(3)
+		if version < 2 {
+			continue // Versions 0 and 1 predate item types
+		}
+		idSize := 2
+		if version >= 3 {
+			idSize = 4
+		}
+		id := uint32(r.uint(idSize))
+		r.uint(2) // protection index
+		itemType := string(binary.BigEndian.AppendUint32(nil, uint32(r.uint(4))))
+		r.cstring() // item name
+		switch itemType {
+		case "Exif":
+			exif = append(exif, id)
+		case "mime":
+			if r.cstring() == "application/rdf+xml" {
+				xmp = append(xmp, id)
+			}
+		}
+		if r.err != nil {
+			return nil, nil, r.err
+		}
+	}
+	return exif, xmp, nil
+}
+
+// heicLocation is where an item's data is stored
+type heicLocation struct {
+	offset uint64 // Absolute file offset
+	length uint64
+	split  bool // Stored in several extents
+}
+
+// heicItemLocations parses the iloc box into absolute file offsets
+func heicItemLocations(b []byte, meta []isoBox) (map[uint32]heicLocation, error) {
+	iloc, ok := findBox(meta, "iloc")
+	if !ok {
+		return nil, errInvalidHEIC
+	}
+	idat, hasIdat := findBox(meta, "idat")
+
+	r := &heicReader{b: b, pos: iloc.data, end: iloc.end}
+	version := r.uint(1)
+	r.uint(3)
+	sizes := r.uint(2)
+	offsetSize, lengthSize := int(sizes>>12&0xF), int(sizes>>8&0xF)
+	baseOffsetSize, indexSize := int(sizes>>4&0xF), 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0xF)
+	}
+	countSize := 2
+	if version == 2 {
+		countSize = 4
+	}
+
+	locations := make(map[uint32]heicLocation)
+	count := r.uint(countSize)
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		id := uint32(r.uint(countSize))
+		method := uint64(0)
+		if version == 1 || version == 2 {
+			method = r.uint(2) & 0xF
+		}
+		r.uint(2) // data reference index
+		base := r.uint(baseOffsetSize)
+		extents := r.uint(2)
+
+		var loc heicLocation
+		for e := uint64(0); e < extents && r.err == nil; e++ {
+			r.uint(indexSize)
+			offset := base + r.uint(offsetSize)
+			length := r.uint(lengthSize)
+			if e == 0 {
+				loc.offset, loc.length = offset, length
+			} else {
+				loc.split = true
+			}
+		}
+
+		switch method {
+		case 0: // File offset
+		case 1: // Offset into idat
+			if !hasIdat {
+				return nil, errInvalidHEIC
+			}
+			loc.offset += uint64(idat.data)
+		default:
+			continue // Items constructed from other items are not metadata
+		}
+		if loc.length == 0 && uint64(len(b)) > loc.offset {
+			loc.length = uint64(len(b)) - loc.offset
+		}
+		locations[id] = loc
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return locations, nil
+}
+
+// heicItemData returns the bytes of an item, sharing memory with b
+func heicItemData(b []byte, locations map[uint32]heicLocation, id uint32) ([]byte, error) {
+	loc, ok := locations[id]
+	if !ok {
+		return nil, fmt.Errorf("metadata item %d has no location", id)
+	}
+	if loc.split {
+		return nil, fmt.Errorf("metadata item %d is fragmented", id)
+	}
+	if loc.offset+loc.length > uint64(len(b)) {
+		return nil, errInvalidHEIC
+	}
+	return b[loc.offset : loc.offset+loc.length], nil
+}
@@ -0,0 +1,192 @@
+package gpm
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// gpsLatitude is the GPS latitude value of testGPSEXIF, looked for in scrubbed output
+var gpsLatitude = []byte{0, 0, 0, 52, 0, 0, 0, 1, 0, 0, 0, 31, 0, 0, 0, 1, 0, 0, 0, 7, 0, 0, 0, 1}
+
+// testGPSEXIF returns big-endian TIFF data with an orientation entry and a GPS IFD
+// holding a latitude
+func testGPSEXIF() []byte {
+	var b bytes.Buffer
+	b.WriteString("MM\x00\x2a")
+	binary.Write(&b, binary.BigEndian, uint32(8))
+	// IFD0 at 8: orientation and the GPS IFD pointer
+	binary.Write(&b, binary.BigEndian, uint16(2))
+	binary.Write(&b, binary.BigEndian, []uint16{tagOrientation, 3, 0, 1, 6, 0})
+	binary.Write(&b, binary.BigEndian, []uint16{tagGPSIFD, 4, 0, 1})
+	binary.Write(&b, binary.BigEndian, uint32(38))
+	binary.Write(&b, binary.BigEndian, uint32(0))
+	// GPS IFD at 38: latitude, three rationals at 56
+	binary.Write(&b, binary.BigEndian, uint16(1))
+	binary.Write(&b, binary.BigEndian, []uint16{0x0002, 5, 0, 3})
+	binary.Write(&b, binary.BigEndian, uint32(56))
+	binary.Write(&b, binary.BigEndian, uint32(0))
+	b.Write(gpsLatitude)
+	return b.Bytes()
+}
+
+// testHEIC builds a minimal HEIF container with an Exif item stored in mdat
+func testHEIC(tiff []byte) []byte {
+	box := func(typ string, payload ...[]byte) []byte {
+		data := bytes.Join(payload, nil)
+		header := binary.BigEndian.AppendUint32(nil, uint32(8+len(data)))
+		return append(append(header, typ...), data...)
+	}
+	u16 := func(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+	u32 := func(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+	ftyp := box("ftyp", []byte("heic"), u32(0), []byte("mif1heic"))
+	item := append(u32(0), tiff...) // TIFF header offset, then the TIFF data
+	infe := box("infe", []byte{2, 0, 0, 0}, u16(1), u16(0), []byte("Exif\x00"))
+	iinf := box("iinf", []byte{0, 0, 0, 0}, u16(1), infe)
+	ilocSize := 8 + 4 + 2 + 2 + 2 + 2 + 2 + 4 + 4
+	metaSize := 8 + 4 + len(iinf) + ilocSize
+	offset := uint32(len(ftyp) + metaSize + 8)
+	iloc := box("iloc", []byte{0, 0, 0, 0}, []byte{0x44, 0x00}, u16(1), u16(1), u16(0), u16(1), u32(offset), u32(uint32(len(item))))
+	meta := box("meta", []byte{0, 0, 0, 0}, iinf, iloc)
+	return bytes.Join([][]byte{ftyp, meta, box("mdat", item)}, nil)
+}
+
+// scrubFile writes data to a file named name, scrubs it and returns the output
+func scrubFile(t *testing.T, transform *ScrubTransform, name string, data []byte) []byte {
+	t.Helper()
+	dir := t.TempDir()
+	input := filepath.Join(dir, name)
+	if err := os.WriteFile(input, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	outDir := filepath.Join(dir, "out")
+	os.Mkdir(outDir, 0o700)
+	output, err := transform.Apply(context.Background(), input, outDir)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	out, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// checkDecodes fails unless data decodes as an image of the given format and size
+func checkDecodes(t *testing.T, data []byte, format string, width, height int) {
+	t.Helper()
+	img, got, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("scrubbed image does not decode: %v", err)
+	}
+	if got != format || img.Bounds().Dx() != width || img.Bounds().Dy() != height {
+		t.Fatalf("scrubbed image is %s %v, want %s %dx%d", got, img.Bounds().Size(), format, width, height)
+	}
+}
+
+func TestScrubJPEG(t *testing.T) {
+	var encoded bytes.Buffer
+	jpeg.Encode(&encoded, testImage(64, 48), nil)
+	xmp := append(bytes.Clone(xmpHeader), `<x:xmpmeta><rdf:Description exif:GPSLatitude="52,31.1N"/></x:xmpmeta>`...)
+	xmpSegment := append([]byte{0xFF, 0xE1, 0, byte(len(xmp) + 2)}, xmp...)
+	withEXIF := withJPEGExif(encoded.Bytes(), testGPSEXIF())
+	data := bytes.Join([][]byte{withEXIF[:2], xmpSegment, withEXIF[2:]}, nil)
+
+	tests := []struct {
+		name      string
+		transform ScrubTransform
+		wantXMP   bool
+	}{
+		{"gps", ScrubTransform{GPS: true}, true},
+		{"all", ScrubTransform{All: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := scrubFile(t, &tt.transform, "photo.jpg", data)
+			checkDecodes(t, out, "jpeg", 64, 48)
+			if bytes.Contains(out, gpsLatitude) {
+				t.Error("GPS latitude was not removed")
+			}
+			if bytes.Contains(out, []byte("52,31.1N")) {
+				t.Error("XMP location was not removed")
+			}
+			if got := bytes.Contains(out, xmpHeader); got != tt.wantXMP {
+				t.Errorf("XMP segment kept = %v, want %v", got, tt.wantXMP)
+			}
+			// Orientation survives, so the image is still displayed upright
+			if !bytes.Contains(out, []byte{0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, 6}) {
+				t.Error("orientation was removed")
+			}
+		})
+	}
+}
+
+func TestScrubPNG(t *testing.T) {
+	var encoded, b bytes.Buffer
+	png.Encode(&encoded, testImage(32, 16))
+	data := encoded.Bytes()
+	b.Write(data[:33])
+	writePNGChunk(&b, "eXIf", testGPSEXIF())
+	writePNGChunk(&b, "tEXt", []byte("Author\x00Jane Doe"))
+	b.Write(data[33:])
+
+	out := scrubFile(t, &ScrubTransform{GPS: true}, "image.png", b.Bytes())
+	checkDecodes(t, out, "png", 32, 16)
+	if bytes.Contains(out, gpsLatitude) {
+		t.Error("GPS latitude was not removed")
+	}
+	if !bytes.Contains(out, []byte("Jane Doe")) {
+		t.Error("text chunk was removed without All")
+	}
+
+	out = scrubFile(t, &ScrubTransform{All: true}, "image.png", b.Bytes())
+	checkDecodes(t, out, "png", 32, 16)
+	if bytes.Contains(out, []byte("Jane Doe")) {
+		t.Error("text chunk was kept with All")
+	}
+}
+
+func TestScrubHEIC(t *testing.T) {
+	data := testHEIC(testGPSEXIF())
+	out := scrubFile(t, &ScrubTransform{GPS: true}, "photo.heic", data)
+
+	if len(out) != len(data) {
+		t.Fatalf("scrubbed size %d, want %d: HEIC item offsets must not move", len(out), len(data))
+	}
+	if bytes.Contains(out, gpsLatitude) {
+		t.Error("GPS latitude was not removed")
+	}
+	boxes, err := readBoxes(out, 0, len(out))
+	if err != nil {
+		t.Fatalf("scrubbed container does not parse: %v", err)
+	}
+	var types []string
+	for _, box := range boxes {
+		types = append(types, box.typ)
+	}
+	if len(types) != 3 || types[0] != "ftyp" || types[1] != "meta" || types[2] != "mdat" {
+		t.Errorf("scrubbed boxes %v, want [ftyp meta mdat]", types)
+	}
+	// Everything but the Exif item is unchanged
+	mdat := boxes[2]
+	if !bytes.Equal(out[:mdat.data], data[:mdat.data]) {
+		t.Error("boxes before the item data changed")
+	}
+}
+
+func TestScrubUnrecognizedFormat(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "photo.jpg")
+	os.WriteFile(input, []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), 0o600)
+
+	output, err := (&ScrubTransform{GPS: true}).Apply(context.Background(), input, t.TempDir())
+	if err != nil || output != input {
+		t.Fatalf("Apply = %q, %v; want the input unchanged", output, err)
+	}
+}
@@ -0,0 +1,120 @@
+package gpm
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// SidecarFormat selects the metadata file written next to a download
+type SidecarFormat string
+
+const (
+	SidecarXMP  SidecarFormat = "xmp"  // <file>.xmp
+	SidecarJSON SidecarFormat = "json" // <file>.json in the layout of Google Takeout
+)
+
+// takeoutSidecar is the subset of a Google Takeout JSON sidecar that DownloadInfo can fill
+type takeoutSidecar struct {
+	Title          string       `json:"title"`
+	Description    string       `json:"description,omitempty"`
+	CreationTime   *takeoutTime `json:"creationTime,omitempty"`
+	PhotoTakenTime *takeoutTime `json:"photoTakenTime,omitempty"`
+	URL            string       `json:"url,omitempty"`
+}
+
+type takeoutTime struct {
+	Timestamp string `json:"timestamp"`
+	Formatted string `json:"formatted"`
+}
+
+// WriteSidecar writes the metadata of a downloaded file next to it and returns the sidecar's
+// path. Only what the download API returns is included: the filename, creation and upload
+// times and the media key. Captions, favourites, locations and archived state are not
+// available and are left out.
+func WriteSidecar(path, mediaKey string, info *DownloadInfo, format SidecarFormat) (string, error) {
+	var data bytes.Buffer
+	switch format {
+	case SidecarXMP:
+		writeXMPSidecar(&data, info)
+	case SidecarJSON:
+		encoder := json.NewEncoder(&data)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(takeoutSidecarOf(mediaKey, info)); err != nil {
+			return "", fmt.Errorf("failed to encode sidecar: %w", err)
+		}
+	default:
+		return "", fmt.Errorf("invalid sidecar format %q (use xmp or json)", format)
+	}
+
+	sidecarPath := path + "." + string(format)
+	if err := writeToFile(sidecarPath, &data); err != nil {
+		return "", err
+	}
+	return sidecarPath, nil
+}
+
+func takeoutSidecarOf(mediaKey string, info *DownloadInfo) takeoutSidecar {
+	sidecar := takeoutSidecar{
+		Title:          info.Filename,
+		CreationTime:   takeoutTimeOf(info.UploadedAt),
+		PhotoTakenTime: takeoutTimeOf(info.CreatedAt),
+	}
+	if mediaKey != "" {
+		sidecar.URL = "https://photos.google.com/photo/" + mediaKey
+	}
+	return sidecar
+}
+
+func takeoutTimeOf(t time.Time) *takeoutTime {
+	if t.IsZero() {
+		return nil
+	}
+	return &takeoutTime{
+		Timestamp: strconv.FormatInt(t.Unix(), 10),
+		Formatted: t.UTC().Format("Jan 2, 2006, 3:04:05 PM UTC"),
+	}
+}
+
+// writeXMPSidecar writes an XMP packet with the title and creation time
+func writeXMPSidecar(b *bytes.Buffer, info *DownloadInfo) {
+	b.WriteString("<?xpacket begin=\"\uFEFF\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	b.WriteString(" <rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n")
+	b.WriteString("  <rdf:Description rdf:about=\"\"\n")
+	b.WriteString("    xmlns:dc=\"http://purl.org/dc/elements/1.1/\"\n")
+	b.WriteString("    xmlns:xmp=\"http://ns.adobe.com/xap/1.0/\"\n")
+	b.WriteString("    xmlns:exif=\"http://ns.adobe.com/exif/1.0/\"\n")
+	b.WriteString("    xmlns:photoshop=\"http://ns.adobe.com/photoshop/1.0/\">\n")
+	if info.Filename != "" {
+		b.WriteString("   <dc:title><rdf:Alt><rdf:li xml:lang=\"x-default\">")
+		xml.EscapeText(b, []byte(info.Filename))
+		b.WriteString("</rdf:li></rdf:Alt></dc:title>\n")
+	}
+	if !info.CreatedAt.IsZero() {
+		created := info.CreatedAt.Format(time.RFC3339)
+		fmt.Fprintf(b, "   <xmp:CreateDate>%s</xmp:CreateDate>\n", created)
+		fmt.Fprintf(b, "   <exif:DateTimeOriginal>%s</exif:DateTimeOriginal>\n", created)
+		fmt.Fprintf(b, "   <photoshop:DateCreated>%s</photoshop:DateCreated>\n", created)
+	}
+	b.WriteString("  </rdf:Description>\n")
+	b.WriteString(" </rdf:RDF>\n")
+	b.WriteString("</x:xmpmeta>\n")
+	b.WriteString("<?xpacket end=\"w\"?>\n")
+}
+
+// restoreFileTimes sets the access and modification times of a download to its creation time
+func restoreFileTimes(path string, info *DownloadInfo) error {
+	if info.CreatedAt.IsZero() {
+		return nil
+	}
+	if err := os.Chtimes(path, info.CreatedAt, info.CreatedAt); err != nil {
+		return fmt.Errorf("failed to set file times: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package gpm
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteSidecarJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	info := &DownloadInfo{
+		Filename:   "photo.jpg",
+		CreatedAt:  time.Date(2020, 5, 17, 14, 30, 0, 0, time.UTC),
+		UploadedAt: time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	sidecarPath, err := WriteSidecar(path, "key", info, SidecarJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sidecarPath != path+".json" {
+		t.Fatalf("sidecar path = %q, want %q", sidecarPath, path+".json")
+	}
+
+	data, err := os.ReadFile(sidecarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	// The caption is not known, so the sidecar must not claim an empty one
+	if _, ok := got["description"]; ok {
+		t.Error("sidecar has a description")
+	}
+	taken, _ := got["photoTakenTime"].(map[string]any)
+	if got["title"] != "photo.jpg" || got["url"] != "https://photos.google.com/photo/key" || taken["timestamp"] != "1589725800" {
+		t.Errorf("sidecar = %s", data)
+	}
+}
+
+func TestWriteSidecarXMP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a&b.jpg")
+	info := &DownloadInfo{Filename: "a&b.jpg", CreatedAt: time.Date(2020, 5, 17, 14, 30, 0, 0, time.UTC)}
+	sidecarPath, err := WriteSidecar(path, "key", info, SidecarXMP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(sidecarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"a&amp;b.jpg", "<xmp:CreateDate>2020-05-17T14:30:00Z</xmp:CreateDate>"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("sidecar does not contain %q:\n%s", want, data)
+		}
+	}
+
+	if _, err := WriteSidecar(path, "key", info, "yaml"); err == nil {
+		t.Error("WriteSidecar accepted an invalid format")
+	}
+}
+
+func TestRestoreFileTimes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.Stat(path)
+
+	// Without a creation time the file is left alone
+	if err := restoreFileTimes(path, &DownloadInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.Stat(path); !after.ModTime().Equal(before.ModTime()) {
+		t.Error("file times changed without a creation time")
+	}
+
+	created := time.Date(2020, 5, 17, 14, 30, 0, 0, time.UTC)
+	if err := restoreFileTimes(path, &DownloadInfo{CreatedAt: created}); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.Stat(path); !after.ModTime().Equal(created) {
+		t.Errorf("modification time = %v, want %v", after.ModTime(), created)
+	}
+
+	if err := restoreFileTimes(filepath.Join(t.TempDir(), "missing"), &DownloadInfo{CreatedAt: created}); err == nil {
+		t.Error("restoreFileTimes of a missing file succeeded")
+	}
+}
@@ -0,0 +1,165 @@
+package gpm
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultTemplateTimeLayout formats {created} and {uploaded} without a layout
+const defaultTemplateTimeLayout = "2006-01-02"
+
+// templateFields are the placeholders a PathTemplate accepts
+var templateFields = map[string]bool{
+	"filename":   true, // Filename with extension
+	"name":       true, // Filename without extension
+	"ext":        true, // Extension without the dot
+	"created":    true, // Creation time, with an optional Go time layout: {created:2006/01}
+	"uploaded":   true, // Upload time, with an optional layout
+	"media_type": true, // photo or video
+	"media_key":  true,
+	"dedup_key":  true, // Known after the download unless given as input
+}
+
+// PathTemplate builds download paths from item metadata, e.g. "{created:2006}/{created:01}/{filename}"
+type PathTemplate struct {
+	parts []templatePart
+}
+
+// templatePart is a literal or a placeholder of a PathTemplate
+type templatePart struct {
+	literal string
+	field   string
+	layout  string
+}
+
+// templateData holds the values of the placeholders for one file
+type templateData struct {
+	info     *DownloadInfo
+	mediaKey string
+	dedupKey string
+}
+
+// ParsePathTemplate parses a download path template. Placeholders are {filename}, {name},
+// {ext}, {created[:layout]}, {uploaded[:layout]}, {media_type}, {media_key} and {dedup_key};
+// times use Go layouts and default to 2006-01-02 in local time. Paths stay below the output
+// directory: ".." directories are rejected in the template and replaced in rendered values.
+func ParsePathTemplate(template string) (*PathTemplate, error) {
+	if strings.TrimSpace(template) == "" {
+		return nil, fmt.Errorf("output template is empty")
+	}
+	for _, dir := range strings.FieldsFunc(template, isPathSeparator) {
+		if dir == ".." {
+			return nil, fmt.Errorf("output template must stay below the output directory: %s", template)
+		}
+	}
+
+	t := &PathTemplate{}
+	rest := template
+	for rest != "" {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			t.parts = append(t.parts, templatePart{literal: rest})
+			break
+		}
+		if start > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:start]})
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed placeholder in output template: %s", rest[start:])
+		}
+
+		field, layout, hasLayout := strings.Cut(rest[start+1:start+end], ":")
+		switch {
+		case field == "album":
+			return nil, fmt.Errorf("{album} is not supported: download info does not include the item's albums")
+		case !templateFields[field]:
+			return nil, fmt.Errorf("unknown placeholder {%s} in output template", field)
+		case hasLayout && field != "created" && field != "uploaded":
+			return nil, fmt.Errorf("placeholder {%s} does not take a layout", field)
+		case hasLayout && layout == "":
+			return nil, fmt.Errorf("empty layout in {%s:}", field)
+		}
+		t.parts = append(t.parts, templatePart{field: field, layout: layout})
+		rest = rest[start+end+1:]
+	}
+	return t, nil
+}
+
+// uses reports whether the template contains a placeholder
+func (t *PathTemplate) uses(field string) bool {
+	for _, part := range t.parts {
+		if part.field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// render builds a relative path. Separators in values are replaced so they cannot add
+// directories, except those of time layouts, and ".." directories are replaced so the path
+// cannot leave the output directory.
+func (t *PathTemplate) render(data templateData) string {
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.field == "" {
+			b.WriteString(part.literal)
+			continue
+		}
+		value := data.value(part.field, part.layout)
+		if value == "" {
+			value = "unknown"
+		}
+		if part.field != "created" && part.field != "uploaded" {
+			value = strings.NewReplacer("/", "_", `\`, "_").Replace(value)
+		}
+		b.WriteString(value)
+	}
+	dirs := strings.FieldsFunc(b.String(), isPathSeparator)
+	for i, dir := range dirs {
+		if dir == ".." {
+			dirs[i] = "_"
+		}
+	}
+	return filepath.Clean(filepath.Join(dirs...))
+}
+
+// isPathSeparator reports whether r separates directories in a template on any platform
+func isPathSeparator(r rune) bool {
+	return r == '/' || r == '\\'
+}
+
+// value returns a placeholder's value, empty if unknown
+func (d templateData) value(field, layout string) string {
+	if layout == "" {
+		layout = defaultTemplateTimeLayout
+	}
+	switch field {
+	case "filename":
+		return d.info.Filename
+	case "name":
+		return strings.TrimSuffix(d.info.Filename, filepath.Ext(d.info.Filename))
+	case "ext":
+		return strings.TrimPrefix(filepath.Ext(d.info.Filename), ".")
+	case "created":
+		return formatTemplateTime(d.info.CreatedAt, layout)
+	case "uploaded":
+		return formatTemplateTime(d.info.UploadedAt, layout)
+	case "media_type":
+		return d.info.MediaType
+	case "media_key":
+		return d.mediaKey
+	case "dedup_key":
+		return d.dedupKey
+	}
+	return ""
+}
+
+func formatTemplateTime(t time.Time, layout string) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(layout)
+}
@@ -0,0 +1,66 @@
+package gpm
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePathTemplateErrors(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"", "empty"},
+		{"{filename", "unclosed placeholder"},
+		{"{size}", "unknown placeholder {size}"},
+		{"{album}/{filename}", "{album} is not supported"},
+		{"{filename:2006}", "does not take a layout"},
+		{"{created:}/{filename}", "empty layout"},
+		{"../{filename}", "must stay below the output directory"},
+		{`photos\..\{filename}`, "must stay below the output directory"},
+		{"{created:2006/../01}/{filename}", "must stay below the output directory"},
+	}
+	for _, tt := range tests {
+		if _, err := ParsePathTemplate(tt.template); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParsePathTemplate(%q) = %v, want an error containing %q", tt.template, err, tt.want)
+		}
+	}
+}
+
+func TestPathTemplateRender(t *testing.T) {
+	created := time.Date(2024, 7, 1, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		name     string
+		template string
+		filename string
+		want     string
+	}{
+		{"filename", "{filename}", "beach.jpg", "beach.jpg"},
+		{"time layout adds directories", "{created:2006/01}/{name}.{ext}", "beach.jpg", "2024/07/beach.jpg"},
+		{"default time layout", "{created}_{media_key}.{ext}", "beach.jpg", "2024-07-01_key.jpg"},
+		{"unknown value", "{media_type}/{filename}", "beach.jpg", "unknown/beach.jpg"},
+		{"dedup key", "{dedup_key}{filename}", "", "DEDUPunknown"},
+		{"separators in values", "{filename}", "a/b\\c.jpg", "a_b_c.jpg"},
+		{"dot-dot value", "{filename}/x.jpg", "..", "_/x.jpg"},
+		{"dot-dot from value and literal", "{name}./{ext}", "..jpg", "_/jpg"},
+		{"dots in a name", "{name}..{ext}", "beach.jpg", "beach..jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := ParsePathTemplate(tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := templateData{
+				info:     &DownloadInfo{Filename: tt.filename, CreatedAt: created},
+				mediaKey: "key",
+				dedupKey: "DEDUP",
+			}
+			if got := tmpl.render(data); got != filepath.FromSlash(tt.want) {
+				t.Errorf("render = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package gpm
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Transform rewrites a file before it is hashed and uploaded
+type Transform interface {
+	// Name identifies the transform in errors
+	Name() string
+	// Match reports whether the transform applies to a file name
+	Match(filename string) bool
+	// Apply writes the transformed file into outputDir and returns its path.
+	// Returning input unchanged means there was nothing to do.
+	Apply(ctx context.Context, input, outputDir string) (string, error)
+}
+
+// CommandTransform runs an external command on matching files.
+// Command arguments support {input}, {output}, {dir}, {name} (input base name without
+// extension) and {ext} (input extension, e.g. ".jxl") placeholders.
+type CommandTransform struct {
+	TransformName string
+	Extensions    []string // Matching extensions, e.g. ".jxl" or "psd" (case-insensitive)
+	Command       []string // Program and arguments, not run through a shell
+	OutputExt     string   // Extension of {output}, e.g. ".jpg"
+}
+
+func (t *CommandTransform) Name() string {
+	return t.TransformName
+}
+
+func (t *CommandTransform) Match(filename string) bool {
+	return matchExtension(filename, t.Extensions)
+}
+
+func (t *CommandTransform) Apply(ctx context.Context, input, outputDir string) (string, error) {
+	if len(t.Command) == 0 {
+		return "", fmt.Errorf("no command configured")
+	}
+	ext := filepath.Ext(input)
+	name := strings.TrimSuffix(filepath.Base(input), ext)
+	outputExt := t.OutputExt
+	if outputExt != "" && !strings.HasPrefix(outputExt, ".") {
+		outputExt = "." + outputExt
+	}
+	output := filepath.Join(outputDir, name+outputExt)
+
+	replacer := strings.NewReplacer(
+		"{input}", input,
+		"{output}", output,
+		"{dir}", outputDir,
+		"{name}", name,
+		"{ext}", ext,
+	)
+	args := make([]string, len(t.Command))
+	for i, arg := range t.Command {
+		args[i] = replacer.Replace(arg)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	if _, err := os.Stat(output); err != nil {
+		return "", fmt.Errorf("command did not produce %s", output)
+	}
+	return output, nil
+}
+
+// DownscaleTransform shrinks JPEG and PNG images larger than MaxMegapixels,
+// keeping the aspect ratio. Pixels are not rotated, so the EXIF block (including its
+// orientation) is copied to the re-encoded image; other metadata is not kept.
+type DownscaleTransform struct {
+	MaxMegapixels float64
+	Quality       int // JPEG quality (default: 90)
+}
+
+func (t *DownscaleTransform) Name() string {
+	return "downscale"
+}
+
+func (t *DownscaleTransform) Match(filename string) bool {
+	return matchExtension(filename, []string{"jpg", "jpeg", "png"})
+}
+
+func (t *DownscaleTransform) Apply(ctx context.Context, input, outputDir string) (string, error) {
+	file, err := os.Open(input)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	cfg, format, err := image.DecodeConfig(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read image header: %w", err)
+	}
+	maxPixels := t.MaxMegapixels * 1e6
+	pixels := float64(cfg.Width) * float64(cfg.Height)
+	if maxPixels <= 0 || pixels <= maxPixels {
+		return input, nil
+	}
+
+	if _, err := file.Seek(0, 0); err != nil {
+		return "", err
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return "", err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	scale := math.Sqrt(maxPixels / pixels)
+	width := max(1, int(float64(cfg.Width)*scale))
+	height := max(1, int(float64(cfg.Height)*scale))
+	resized := downscaleImage(img, width, height)
+
+	var encoded bytes.Buffer
+	switch format {
+	case "png":
+		if err = png.Encode(&encoded, resized); err == nil {
+			copyPNGExif(&encoded, data)
+		}
+	default:
+		quality := t.Quality
+		if quality <= 0 {
+			quality = 90
+		}
+		if err = jpeg.Encode(&encoded, resized, &jpeg.Options{Quality: quality}); err == nil {
+			copyJPEGExif(&encoded, data)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	output := filepath.Join(outputDir, filepath.Base(input))
+	if err := os.WriteFile(output, encoded.Bytes(), 0o600); err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
+// copyJPEGExif inserts the EXIF segments of the original JPEG after the start
+// marker of the encoded one
+func copyJPEGExif(encoded *bytes.Buffer, original []byte) {
+	var exif []byte
+	for pos := 2; pos+4 <= len(original) && original[pos] == 0xFF; {
+		marker := original[pos+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		end := pos + 2 + int(binary.BigEndian.Uint16(original[pos+2:]))
+		if end > len(original) {
+			break
+		}
+		if marker == 0xE1 && bytes.HasPrefix(original[pos+4:end], exifHeader) {
+			exif = append(exif, original[pos:end]...)
+		}
+		pos = end
+	}
+	if len(exif) == 0 {
+		return
+	}
+	out := slices.Concat(encoded.Bytes()[:2], exif, encoded.Bytes()[2:])
+	encoded.Reset()
+	encoded.Write(out)
+}
+
+// copyPNGExif inserts the eXIf chunk of the original PNG after the header chunk
+// of the encoded one
+func copyPNGExif(encoded *bytes.Buffer, original []byte) {
+	for pos := len(pngSignature); pos+12 <= len(original); {
+		length := int(binary.BigEndian.Uint32(original[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(original) {
+			return
+		}
+		switch string(original[pos+4 : pos+8]) {
+		case "eXIf":
+			// IHDR is always the first chunk, with 13 bytes of data
+			const headerEnd = 8 + 12 + 13
+			out := slices.Concat(encoded.Bytes()[:headerEnd], original[pos:end], encoded.Bytes()[headerEnd:])
+			encoded.Reset()
+			encoded.Write(out)
+			return
+		case "IDAT", "IEND":
+			return
+		}
+		pos = end
+	}
+}
+
+// downscaleImage resizes src to width x height by averaging the source pixels
+// covered by each destination pixel (box filter). Source rows are converted with
+// draw, which has fast paths for the decoded image types.
+func downscaleImage(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	sums := make([][4]uint64, width*height)
+	counts := make([]uint64, width*height)
+
+	// Destination column of each source column
+	columns := make([]int, srcW)
+	for x := range columns {
+		columns[x] = x * width / srcW
+	}
+
+	row := image.NewRGBA(image.Rect(0, 0, srcW, 1))
+	for y := 0; y < srcH; y++ {
+		draw.Draw(row, row.Rect, src, image.Pt(bounds.Min.X, bounds.Min.Y+y), draw.Src)
+		offset := y * height / srcH * width
+		for x, dx := range columns {
+			sum := &sums[offset+dx]
+			pix := row.Pix[x*4 : x*4+4]
+			sum[0] += uint64(pix[0])
+			sum[1] += uint64(pix[1])
+			sum[2] += uint64(pix[2])
+			sum[3] += uint64(pix[3])
+			counts[offset+dx]++
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i, sum := range sums {
+		n := max(counts[i], 1)
+		offset := i * 4
+		dst.Pix[offset] = uint8(sum[0] / n)
+		dst.Pix[offset+1] = uint8(sum[1] / n)
+		dst.Pix[offset+2] = uint8(sum[2] / n)
+		dst.Pix[offset+3] = uint8(sum[3] / n)
+	}
+	return dst
+}
+
+// matchExtension reports whether filename has one of the extensions (with or without dot)
+func matchExtension(filename string, extensions []string) bool {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	if ext == "" {
+		return false
+	}
+	return slices.ContainsFunc(extensions, func(e string) bool {
+		return strings.TrimPrefix(strings.ToLower(e), ".") == ext
+	})
+}
+
+// matchesTransform reports whether any of the transforms applies to filename
+func matchesTransform(filename string, transforms []Transform) bool {
+	return slices.ContainsFunc(transforms, func(t Transform) bool { return t.Match(filename) })
+}
+
+// applyTransforms runs the matching transforms in order on the source file.
+// Outputs are written to a temporary directory that is removed by src.cleanup.
+func applyTransforms(ctx context.Context, src *uploadSource, opts UploadOptions) error {
+	if !matchesTransform(src.name, opts.Transforms) {
+		return nil
+	}
+
+	dir, err := os.MkdirTemp(opts.TempDir, "gpm-transform-*")
+	if err != nil {
+		return &UploadError{Op: "transform", Err: fmt.Errorf("failed to create temp dir: %w", err)}
+	}
+	cleanup := src.cleanup
+	src.cleanup = func() {
+		cleanup()
+		os.RemoveAll(dir)
+	}
+
+	current, name := src.localPath, src.name
+	for i, t := range opts.Transforms {
+		if !t.Match(name) {
+			continue
+		}
+		// Each step writes to its own directory so outputs never overwrite their input
+		stepDir := filepath.Join(dir, strconv.Itoa(i))
+		if err := os.Mkdir(stepDir, 0o700); err != nil {
+			return &UploadError{Op: "transform", Err: err}
+		}
+		output, err := t.Apply(ctx, current, stepDir)
+		if err != nil {
+			return &UploadError{Op: "transform", Err: fmt.Errorf("%s: %w", t.Name(), err)}
+		}
+		if output != current {
+			current = output
+			name = strings.TrimSuffix(name, filepath.Ext(name)) + filepath.Ext(output)
+		}
+	}
+	if current == src.localPath {
+		return nil
+	}
+
+	info, err := os.Stat(current)
+	if err != nil {
+		return &UploadError{Op: "transform", Err: err}
+	}
+
+	// Keep the original's hash so the uploaded item can be traced back to it
+	src.originalSHA1 = src.sha1
+	if src.originalSHA1 == nil {
+		if src.originalSHA1, err = CalculateSHA1(ctx, src.localPath); err != nil {
+			return &UploadError{Op: "hash", Err: err}
+		}
+	}
+	src.localPath = current
+	src.name = name
+	src.size = info.Size()
+	src.sha1 = nil
+	src.transformed = true
+	return nil
+}
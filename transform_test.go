@@ -0,0 +1,148 @@
+package gpm
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testImage returns a gradient image, so scaling has something to average
+func testImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), uint8(x + y), 255})
+		}
+	}
+	return img
+}
+
+// testEXIF returns big-endian TIFF data with a single orientation entry
+func testEXIF(orientation uint16) []byte {
+	var b bytes.Buffer
+	b.WriteString("MM\x00\x2a")
+	binary.Write(&b, binary.BigEndian, uint32(8))
+	binary.Write(&b, binary.BigEndian, uint16(1))
+	binary.Write(&b, binary.BigEndian, []uint16{tagOrientation, 3})
+	binary.Write(&b, binary.BigEndian, uint32(1))
+	binary.Write(&b, binary.BigEndian, []uint16{orientation, 0})
+	binary.Write(&b, binary.BigEndian, uint32(0))
+	return b.Bytes()
+}
+
+// withJPEGExif inserts an APP1 EXIF segment after the start marker of a JPEG
+func withJPEGExif(data, tiff []byte) []byte {
+	payload := append(bytes.Clone(exifHeader), tiff...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return bytes.Join([][]byte{data[:2], segment, payload, data[2:]}, nil)
+}
+
+func TestDownscaleKeepsEXIF(t *testing.T) {
+	tiff := testEXIF(6)
+	tests := []struct {
+		name   string
+		encode func() []byte
+	}{
+		{"jpeg", func() []byte {
+			var b bytes.Buffer
+			jpeg.Encode(&b, testImage(400, 300), nil)
+			return withJPEGExif(b.Bytes(), tiff)
+		}},
+		{"png", func() []byte {
+			var encoded, b bytes.Buffer
+			png.Encode(&encoded, testImage(400, 300))
+			data := encoded.Bytes()
+			b.Write(data[:33])
+			writePNGChunk(&b, "eXIf", tiff)
+			b.Write(data[33:])
+			return b.Bytes()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			input := filepath.Join(dir, "in."+tt.name)
+			if err := os.WriteFile(input, tt.encode(), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			outDir := filepath.Join(dir, "out")
+			os.Mkdir(outDir, 0o700)
+
+			transform := &DownscaleTransform{MaxMegapixels: 0.03}
+			output, err := transform.Apply(context.Background(), input, outDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("output does not decode: %v", err)
+			}
+			if format != tt.name || cfg.Width*cfg.Height > 30000 || cfg.Width != 200 {
+				t.Errorf("output is %s %dx%d, want %s 200x150", format, cfg.Width, cfg.Height, tt.name)
+			}
+			if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+				t.Fatalf("output does not decode: %v", err)
+			}
+			if !bytes.Contains(data, tiff) {
+				t.Error("EXIF orientation was not copied to the output")
+			}
+		})
+	}
+}
+
+func TestDownscaleSkipsSmallImages(t *testing.T) {
+	var b bytes.Buffer
+	png.Encode(&b, testImage(10, 10))
+	input := filepath.Join(t.TempDir(), "small.png")
+	os.WriteFile(input, b.Bytes(), 0o600)
+
+	output, err := (&DownscaleTransform{MaxMegapixels: 1}).Apply(context.Background(), input, t.TempDir())
+	if err != nil || output != input {
+		t.Fatalf("Apply = %q, %v; want the input unchanged", output, err)
+	}
+}
+
+func TestDownscaleImageAverages(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	src.Set(0, 0, color.RGBA{0, 0, 0, 255})
+	src.Set(1, 0, color.RGBA{100, 0, 0, 255})
+	src.Set(0, 1, color.RGBA{200, 0, 0, 255})
+	src.Set(1, 1, color.RGBA{100, 0, 0, 255})
+
+	got := downscaleImage(src, 1, 1).RGBAAt(0, 0)
+	if want := (color.RGBA{100, 0, 0, 255}); got != want {
+		t.Errorf("downscaleImage = %v, want %v", got, want)
+	}
+}
+
+func TestCleanupHostKeepsTransformedOriginals(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	os.WriteFile(path, []byte("original"), 0o600)
+	src := &uploadSource{localPath: path + ".scrubbed", transformed: true}
+
+	removed, _, err := cleanupHost(context.Background(), nil, path, "", "key", src, true, UploadOptions{Delete: DeleteAlways})
+	if removed || !errors.Is(err, ErrOriginalKept) {
+		t.Fatalf("cleanupHost = %v, %v; want the original kept with ErrOriginalKept", removed, err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("original was removed: %v", err)
+	}
+
+	opts := UploadOptions{Delete: DeleteAlways, DeleteTransformed: true}
+	if removed, _, err := cleanupHost(context.Background(), nil, path, "", "key", src, true, opts); !removed || err != nil {
+		t.Fatalf("cleanupHost with DeleteTransformed = %v, %v; want removed", removed, err)
+	}
+}
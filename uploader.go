@@ -2,11 +2,13 @@ package gpm
 
 import (
 	"context"
-	"encoding/base64"
+	"errors"
 	"fmt"
-	"log/slog"
+	"net"
 	"os"
-	"sync"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/viperadnan-git/go-gpm/internal/core"
 )
@@ -15,13 +17,21 @@ import (
 type UploadStatus string
 
 const (
-	StatusHashing    UploadStatus = "hashing"
-	StatusChecking   UploadStatus = "checking"
-	StatusUploading  UploadStatus = "uploading"
-	StatusFinalizing UploadStatus = "finalizing"
-	StatusCompleted  UploadStatus = "completed"
-	StatusSkipped    UploadStatus = "skipped" // Already in library
-	StatusFailed     UploadStatus = "failed"
+	StatusHashing        UploadStatus = "hashing"
+	StatusChecking       UploadStatus = "checking"
+	StatusUploading      UploadStatus = "uploading"
+	StatusFinalizing     UploadStatus = "finalizing"
+	StatusRetrying       UploadStatus = "retrying" // Transient failure, will retry after backoff
+	StatusVerifying      UploadStatus = "verifying"
+	StatusCompleted      UploadStatus = "completed"
+	StatusSkipped        UploadStatus = "skipped"   // Already in library
+	StatusDuplicate      UploadStatus = "duplicate" // Same content as another file of the batch (see CanonicalPath)
+	StatusFailed         UploadStatus = "failed"
+	StatusVerifyFailed   UploadStatus = "verify_failed"   // Uploaded, but server copy does not match local file
+	StatusCanceled       UploadStatus = "canceled"        // Not started because the job was stopped (see UploadHandle.Stop)
+	StatusAlbumAdded     UploadStatus = "album_added"     // Added to UploadOptions' album, follows the file's final event
+	StatusAlbumFailed    UploadStatus = "album_failed"    // Adding to the album failed; the upload itself succeeded
+	StatusMetadataFailed UploadStatus = "metadata_failed" // Uploaded or skipped, but setting its metadata failed; follows the file's final event
 )
 
 // UploadEvent represents a status update for a file upload
@@ -32,171 +42,257 @@ type UploadEvent struct {
 	DedupKey string
 	Error    error
 	WorkerID int
-	Total    int // Total files in batch (set on first event)
+	Attempt  int           // Retry attempt number (set on StatusRetrying)
+	Total    int           // Files in batch, sent once in its own event as soon as they are counted
+	Bytes    int64         // File size, once hashed
+	Duration time.Duration // Time spent on the file so far
+
+	CanonicalPath    string // File of the batch that was uploaded in place of this one (set on StatusDuplicate)
+	TransformedPath  string // Temporary file uploaded in place of Path; removed when the file is done, possibly before its final event is read
+	OriginalDedupKey string // Dedup key of Path before transforms (DedupKey is of the uploaded file)
+	AlbumKey         string // Album the file was added to (set on StatusAlbumAdded)
+	AlbumName        string // Name of an album created by the job (set on album events)
+
+	// Local file removal (set on StatusCompleted/StatusSkipped when a delete mode is active)
+	Removed     bool   // Local file was deleted or moved
+	MovedTo     string // Quarantine path when moved (UploadOptions.MoveTo)
+	DeleteError error  // Removal failed or was refused; the upload itself succeeded
+}
+
+// UploadError describes which step of a file upload failed
+type UploadError struct {
+	Op  string // "stat", "fetch", "transform", "hash", "upload token", "upload", "commit", "verify", "album" or "metadata"
+	Err error
+}
+
+func (e *UploadError) Error() string {
+	return e.Op + " error: " + e.Err.Error()
+}
+
+func (e *UploadError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorCode returns a short, stable code for an upload error, e.g. "commit" or "http_429".
+// It returns "" for a nil error and "unknown" when the failing step is not known.
+func ErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, context.Canceled) {
+		return "canceled"
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	var verifyErr *VerifyError
+	if errors.As(err, &verifyErr) {
+		return "verify_mismatch"
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return fmt.Sprintf("http_%d", httpErr.StatusCode)
+	}
+	var uploadErr *UploadError
+	if errors.As(err, &uploadErr) {
+		return strings.ReplaceAll(uploadErr.Op, " ", "_")
+	}
+	return "unknown"
+}
+
+// VerifyError lists the differences found between a local file and its uploaded copy
+type VerifyError struct {
+	MediaKey   string
+	Mismatches []string
+}
+
+func (e *VerifyError) Error() string {
+	return "verification failed: " + strings.Join(e.Mismatches, "; ")
+}
+
+// IsRetryable reports whether err is a transient failure worth retrying: HTTP 429 and 5xx
+// responses, network timeouts and refused or reset connections. Other transport errors,
+// such as TLS failures or invalid URLs, are not retried.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == 429 || httpErr.StatusCode >= 500
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
 }
 
 // UploadOptions contains runtime options for upload operations
 type UploadOptions struct {
-	Workers         int
-	Recursive       bool
-	ForceUpload     bool
-	DeleteFromHost  bool
-	DisableFilter   bool
-	Caption         string
-	ShouldFavourite bool
-	ShouldArchive   bool
-	Quality         string // "original" or "storage-saver"
-	UseQuota        bool
+	Workers           int // Concurrent uploads of the job (default: 3); the shared pool grows to the largest job setting
+	HashWorkers       int // Concurrent hashing, reading files from disk (default: 2)
+	CheckWorkers      int // Concurrent checks whether files are already in the library (default: Workers)
+	CommitWorkers     int // Concurrent commits and post-upload options (default: Workers)
+	Recursive         bool
+	FollowSymlinks    bool // Descend into symlinked directories
+	SkipHidden        bool // Skip dot files and directories
+	OneFileSystem     bool // Stay on the file system of the upload path
+	ForceUpload       bool
+	DisableFilter     bool
+	Caption           string
+	ShouldFavourite   bool
+	ShouldArchive     bool
+	Quality           string // "original" or "storage-saver"
+	UseQuota          bool
+	Retries           int           // Per-file retries of transient errors, replacing the HTTP client's own retries (0 = client retries only)
+	RetryBackoff      time.Duration // Initial retry delay, doubled on each attempt (default: 2s)
+	Verify            bool          // Check the server copy against the local file after upload
+	Delete            DeleteMode    // Remove local files after upload (see DeleteMode)
+	DeleteFromHost    bool          // Deprecated: use Delete; true is the same as DeleteAlways
+	MoveTo            string        // Move files below this directory instead of deleting them
+	DeleteAfter       time.Duration // Only remove files that have been in the library at least this long
+	DeleteTransformed bool          // Also remove files of which only a transformed copy was uploaded (see ErrOriginalKept)
+	TempDir           string        // Directory for temporary files (default: os.TempDir())
+	Transforms        []Transform   // Applied in order before hashing; matching files are uploaded even if unsupported
+	AlbumKey          string        // Add uploaded and already present files to this album while uploading
+	AlbumName         string        // Create an album with this name for the first batch if AlbumKey is empty
+	AlbumBatchSize    int           // Files per album request (default: 50)
 }
 
-// Upload uploads files to Google Photos and returns a channel for status events.
-// The channel is closed when upload completes. Multiple calls are queued automatically.
-func (g *GooglePhotosAPI) Upload(ctx context.Context, path string, opts UploadOptions) <-chan UploadEvent {
-	events := make(chan UploadEvent)
+// walkOptions returns the directory walk settings
+func (opts UploadOptions) walkOptions() WalkOptions {
+	return WalkOptions{
+		Recursive:      opts.Recursive,
+		FollowSymlinks: opts.FollowSymlinks,
+		SkipHidden:     opts.SkipHidden,
+		OneFileSystem:  opts.OneFileSystem,
+	}
+}
 
-	go func() {
-		// Serialize upload batches
-		g.uploadMu.Lock()
-		defer g.uploadMu.Unlock()
-		defer close(events)
+// wantsFile reports whether a file found in a directory should be uploaded.
+// Files matching a transform are kept even if Google Photos does not support them.
+func (opts UploadOptions) wantsFile(path string) bool {
+	return opts.DisableFilter || IsSupportedByGooglePhotos(path) || matchesTransform(path, opts.Transforms)
+}
 
-		// Filter files
-		files, err := GetGooglePhotosSupportedFiles(path, opts.Recursive, opts.DisableFilter)
-		if err != nil {
-			events <- UploadEvent{Status: StatusFailed, Error: err}
-			return
-		}
-		if len(files) == 0 {
-			return
-		}
+// deleteMode returns the effective delete mode (DeleteFromHost, MoveTo and DeleteAfter
+// imply DeleteAlways)
+func (opts UploadOptions) deleteMode() DeleteMode {
+	if opts.Delete == DeleteNever && (opts.DeleteFromHost || opts.MoveTo != "" || opts.DeleteAfter > 0) {
+		return DeleteAlways
+	}
+	return opts.Delete
+}
 
-		// Send total count with first event
-		workers := max(1, opts.Workers)
-		workers = min(workers, len(files))
-
-		workChan := make(chan string, len(files))
-		var wg sync.WaitGroup
-
-		// Start workers
-		for i := range workers {
-			wg.Add(1)
-			go func(workerID int) {
-				defer wg.Done()
-				for path := range workChan {
-					select {
-					case <-ctx.Done():
-						return
-					default:
-					}
-					uploadFile(ctx, g.Api, path, workerID, opts, events)
-				}
-			}(i)
-		}
+// Upload uploads files to Google Photos and returns a channel for status events.
+// The channel is closed when upload completes. Multiple calls run concurrently on
+// the shared upload pipeline; use Submit for priorities and cancellation.
+func (g *GooglePhotosAPI) Upload(ctx context.Context, path string, opts UploadOptions) <-chan UploadEvent {
+	return g.Submit(ctx, UploadJob{Path: path, Options: opts}).Events()
+}
 
-		// Send work (with total on first)
-		first := true
-		for _, path := range files {
-			select {
-			case <-ctx.Done():
-				close(workChan)
-				wg.Wait()
-				return
-			default:
-			}
-			if first {
-				events <- UploadEvent{Total: len(files)}
-				first = false
-			}
-			workChan <- path
-		}
-		close(workChan)
-		wg.Wait()
-	}()
+// UploadFiles uploads the given list of files and returns a channel for status events.
+// Files are not filtered by type. The channel is closed when upload completes.
+func (g *GooglePhotosAPI) UploadFiles(ctx context.Context, files []string, opts UploadOptions) <-chan UploadEvent {
+	return g.Submit(ctx, UploadJob{Files: files, Options: opts}).Events()
+}
 
-	return events
+// uploadSource is the local data behind an upload
+type uploadSource struct {
+	localPath    string // File to read (a temporary spool for URLs)
+	name         string // Filename committed to the library
+	size         int64
+	modTime      time.Time
+	sha1         []byte
+	remote       bool   // Fetched from a URL, nothing to remove on the host
+	transformed  bool   // localPath is the output of UploadOptions.Transforms
+	originalSHA1 []byte // Hash of the file before transforms
+	cleanup      func() // Removes temporary files (never nil)
 }
 
-func uploadFile(ctx context.Context, api *core.Api, filePath string, workerID int, opts UploadOptions, events chan<- UploadEvent) {
-	send := func(status UploadStatus, mediaKey, dedupKey string, err error) {
-		events <- UploadEvent{
-			Path: filePath, Status: status, MediaKey: mediaKey, DedupKey: dedupKey, Error: err, WorkerID: workerID,
+// openSource stats a local file or spools a URL, applies transforms and hashes the result
+func openSource(ctx context.Context, api *core.Api, path string, opts UploadOptions) (*uploadSource, error) {
+	var src *uploadSource
+	if IsURL(path) {
+		var err error
+		if src, err = spoolURL(ctx, api, path, opts.TempDir); err != nil {
+			return nil, err
 		}
-	}
-
-	// Hash file
-	send(StatusHashing, "", "", nil)
-	sha1Hash, err := CalculateSHA1(ctx, filePath)
-	if err != nil {
-		send(StatusFailed, "", "", fmt.Errorf("hash error: %w", err))
-		return
-	}
-	dedupKey := core.SHA1ToDedupeKey(sha1Hash)
-
-	// Check if exists
-	if !opts.ForceUpload {
-		send(StatusChecking, "", dedupKey, nil)
-		if mediaKey, _ := api.FindMediaKeyByHash(ctx, sha1Hash); mediaKey != "" {
-			if opts.DeleteFromHost {
-				os.Remove(filePath)
-			}
-			send(StatusSkipped, mediaKey, dedupKey, nil)
-			return
+	} else {
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			return nil, &UploadError{Op: "stat", Err: err}
+		}
+		src = &uploadSource{
+			localPath: path,
+			name:      fileInfo.Name(),
+			size:      fileInfo.Size(),
+			modTime:   fileInfo.ModTime(),
+			cleanup:   func() {},
 		}
 	}
 
-	// Get file info
-	fileInfo, err := os.Stat(filePath)
-	if err != nil {
-		send(StatusFailed, "", dedupKey, fmt.Errorf("stat error: %w", err))
-		return
+	if err := applyTransforms(ctx, src, opts); err != nil {
+		src.cleanup()
+		return nil, err
 	}
 
-	// Upload
-	send(StatusUploading, "", dedupKey, nil)
-	sha1Base64 := base64.StdEncoding.EncodeToString([]byte(sha1Hash))
-	token, err := api.GetUploadToken(ctx, sha1Base64, fileInfo.Size())
-	if err != nil {
-		send(StatusFailed, "", dedupKey, fmt.Errorf("upload token error: %w", err))
-		return
+	// Spooled URLs are hashed while downloading
+	if src.sha1 == nil {
+		sha1Hash, err := CalculateSHA1(ctx, src.localPath)
+		if err != nil {
+			src.cleanup()
+			return nil, &UploadError{Op: "hash", Err: err}
+		}
+		src.sha1 = sha1Hash
 	}
+	return src, nil
+}
 
-	commitToken, err := api.UploadFile(ctx, filePath, token)
+// verifyUpload compares the uploaded media item with the local file.
+// Size is only compared for original quality, as storage-saver items are recompressed.
+func verifyUpload(ctx context.Context, api *core.Api, mediaKey string, src *uploadSource, opts UploadOptions) error {
+	info, err := api.GetDownloadInfo(ctx, mediaKey)
 	if err != nil {
-		send(StatusFailed, "", dedupKey, fmt.Errorf("upload error: %w", err))
-		return
+		return &UploadError{Op: "verify", Err: err}
 	}
+	return verifyItem(ctx, api, mediaKey, info, src, opts)
+}
 
-	// Finalize
-	send(StatusFinalizing, "", dedupKey, nil)
-	mediaKey, err := api.CommitUpload(ctx, commitToken, fileInfo.Name(), sha1Hash, fileInfo.ModTime().Unix(), opts.Quality, opts.UseQuota)
-	if err != nil {
-		send(StatusFailed, "", dedupKey, fmt.Errorf("commit error: %w", err))
-		return
-	}
-	if mediaKey == "" {
-		send(StatusFailed, "", dedupKey, fmt.Errorf("no media key returned"))
-		return
+// verifyItem compares the download info of a media item with the local file
+func verifyItem(ctx context.Context, api *core.Api, mediaKey string, info *DownloadInfo, src *uploadSource, opts UploadOptions) error {
+	quality := opts.Quality
+	if quality == "" {
+		quality = api.Quality
 	}
 
-	// Post-upload ops
-	if opts.Caption != "" {
-		if err := api.SetCaption(ctx, mediaKey, opts.Caption); err != nil {
-			slog.Error("caption failed", "path", filePath, "error", err)
-		}
+	var mismatches []string
+	if info.Filename != src.name {
+		mismatches = append(mismatches, fmt.Sprintf("filename: local %q, remote %q", src.name, info.Filename))
 	}
-	if opts.ShouldFavourite {
-		if err := api.SetFavourite(ctx, mediaKey, true); err != nil {
-			slog.Error("favourite failed", "path", filePath, "error", err)
-		}
+	if quality != "storage-saver" && info.FileSize != src.size {
+		mismatches = append(mismatches, fmt.Sprintf("size: local %d, remote %d", src.size, info.FileSize))
 	}
-	if opts.ShouldArchive {
-		if err := api.SetArchived(ctx, []string{mediaKey}, true); err != nil {
-			slog.Error("archive failed", "path", filePath, "error", err)
+	// QualityPercentage is 0 when not reported by the server
+	if pct := info.QualityPercentage; pct > 0 {
+		if quality == "storage-saver" && pct >= 100 {
+			mismatches = append(mismatches, fmt.Sprintf("quality: requested storage-saver, stored at %d%%", pct))
+		} else if quality != "storage-saver" && pct < 100 {
+			mismatches = append(mismatches, fmt.Sprintf("quality: requested original, stored at %d%%", pct))
 		}
 	}
-	if opts.DeleteFromHost {
-		os.Remove(filePath)
+
+	hashKey, err := api.FindMediaKeyByHash(ctx, src.sha1)
+	if err != nil {
+		return &UploadError{Op: "verify", Err: err}
+	}
+	if hashKey != mediaKey {
+		mismatches = append(mismatches, fmt.Sprintf("hash: lookup returned %q", hashKey))
 	}
 
-	send(StatusCompleted, mediaKey, dedupKey, nil)
+	if len(mismatches) > 0 {
+		return &VerifyError{MediaKey: mediaKey, Mismatches: mismatches}
+	}
+	return nil
 }
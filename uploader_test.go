@@ -0,0 +1,66 @@
+package gpm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+)
+
+// timeoutError is a net.Error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var _ net.Error = timeoutError{}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"canceled", &UploadError{Op: "upload", Err: context.Canceled}, false},
+		{"deadline", context.DeadlineExceeded, false},
+		{"rate limited", &UploadError{Op: "commit", Err: &HTTPError{StatusCode: 429}}, true},
+		{"server error", &HTTPError{StatusCode: 503}, true},
+		{"client error", &HTTPError{StatusCode: 400}, false},
+		{"connection reset", fmt.Errorf("upload: %w", syscall.ECONNRESET), true},
+		{"connection refused", syscall.ECONNREFUSED, true},
+		{"network timeout", &net.OpError{Op: "read", Err: timeoutError{}}, true},
+		{"verify mismatch", &VerifyError{MediaKey: "key", Mismatches: []string{"size"}}, false},
+		{"other", errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Fatalf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{&UploadError{Op: "upload", Err: context.Canceled}, "canceled"},
+		{fmt.Errorf("commit: %w", context.DeadlineExceeded), "timeout"},
+		{&UploadError{Op: "verify", Err: &VerifyError{MediaKey: "key"}}, "verify_mismatch"},
+		{&UploadError{Op: "commit", Err: &HTTPError{StatusCode: 429}}, "http_429"},
+		{&UploadError{Op: "upload token", Err: errors.New("boom")}, "upload_token"},
+		{errors.New("boom"), "unknown"},
+	}
+	for _, tt := range tests {
+		if got := ErrorCode(tt.err); got != tt.want {
+			t.Errorf("ErrorCode(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
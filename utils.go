@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -41,9 +40,24 @@ func DownloadFromReader(reader io.Reader, outputPath, filename string) (string,
 }
 
 // DownloadFile downloads a file from the given URL with a specified filename
-// If filename is empty, it will be extracted from Content-Disposition header or URL
+// If filename is empty, it will be extracted from Content-Disposition header or URL.
+// With a filename, interrupted downloads are resumed from "<path>.part" (see SaveDownload).
+// It uses http.DefaultClient, so it does not go through an API client's proxy or retries
+// and cannot be cancelled; use SaveDownload or OpenDownloadInfo for library items.
 func DownloadFile(downloadURL, outputPath, filename string) (string, error) {
-	resp, err := http.Get(downloadURL)
+	if filename != "" {
+		filePath := resolveOutputPath(outputPath, filename)
+		if _, err := fetch(context.Background(), openDefault, downloadURL, filePath+partSuffix, downloadURL, 0); err != nil {
+			return "", err
+		}
+		if err := os.Rename(filePath+partSuffix, filePath); err != nil {
+			return "", fmt.Errorf("failed to rename %s: %w", filePath+partSuffix, err)
+		}
+		return filePath, nil
+	}
+
+	// The response names the file, so there is no part to resume
+	resp, err := openDefault(context.Background(), downloadURL, 0, "")
 	if err != nil {
 		return "", fmt.Errorf("download request failed: %w", err)
 	}
@@ -53,17 +67,13 @@ func DownloadFile(downloadURL, outputPath, filename string) (string, error) {
 		return "", fmt.Errorf("download failed with status %d", resp.StatusCode)
 	}
 
-	// Use provided filename, or extract from response
-	if filename == "" {
-		filename = extractFilenameFromContentDisposition(resp.Header.Get("Content-Disposition"))
-	}
+	filename = extractFilenameFromContentDisposition(resp.Header.Get("Content-Disposition"))
 	if filename == "" {
 		filename = extractFilenameFromURL(downloadURL)
 	}
 	if filename == "" {
 		filename = "download"
 	}
-
 	return DownloadFromReader(resp.Body, outputPath, filename)
 }
 
@@ -130,19 +140,24 @@ func resolveOutputPath(outputPath, filename string) string {
 	return outputPath
 }
 
-// writeToFile writes data from reader to file
+// writeToFile writes data from reader to "<filePath>.part" and renames it to filePath
+// once complete, so an interrupted write never leaves a truncated file at filePath
 func writeToFile(filePath string, reader io.Reader) error {
-	outFile, err := os.Create(filePath)
+	partPath := filePath + partSuffix
+	outFile, err := os.Create(partPath)
 	if err != nil {
 		return fmt.Errorf("failed to create output file: %w", err)
 	}
-	defer outFile.Close()
 
 	_, err = io.Copy(outFile, reader)
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
+		os.Remove(partPath)
 		return fmt.Errorf("failed to write file: %w", err)
 	}
-	return nil
+	return os.Rename(partPath, filePath)
 }
 
 // ResolveItemKey resolves input to an item key (dedupKey for file paths)
@@ -178,24 +193,31 @@ func (g *GooglePhotosAPI) ResolveItemKey(ctx context.Context, input string) (str
 // Otherwise returns input as-is (assumed to be mediaKey)
 // Use this for APIs that require mediaKey (thumbnail, download)
 func (g *GooglePhotosAPI) ResolveMediaKey(ctx context.Context, input string) (string, error) {
+	mediaKey, _, err := g.resolveMediaKey(ctx, input)
+	return mediaKey, err
+}
+
+// resolveMediaKey is ResolveMediaKey that also returns the SHA1 the lookup used,
+// nil if input was a media key
+func (g *GooglePhotosAPI) resolveMediaKey(ctx context.Context, input string) (string, []byte, error) {
 	if input == "" {
-		return "", fmt.Errorf("item key or file path is required")
+		return "", nil, fmt.Errorf("item key or file path is required")
 	}
 
 	// If input looks like a dedup key, convert to hash and look up mediaKey
 	if DedupKeyPattern.MatchString(input) {
 		hash, err := core.DedupeKeyToSHA1(input)
 		if err != nil {
-			return "", fmt.Errorf("failed to decode dedup key: %w", err)
+			return "", nil, fmt.Errorf("failed to decode dedup key: %w", err)
 		}
 		mediaKey, err := g.FindMediaKeyByHash(ctx, hash)
 		if err != nil {
-			return "", fmt.Errorf("failed to find media in library: %w", err)
+			return "", nil, fmt.Errorf("failed to find media in library: %w", err)
 		}
 		if mediaKey == "" {
-			return "", fmt.Errorf("media not found in Google Photos library")
+			return "", nil, fmt.Errorf("media not found in Google Photos library")
 		}
-		return mediaKey, nil
+		return mediaKey, hash, nil
 	}
 
 	// Check if input is a file path by trying to stat it
@@ -203,19 +225,19 @@ func (g *GooglePhotosAPI) ResolveMediaKey(ctx context.Context, input string) (st
 		// File exists, calculate SHA1 and look up mediaKey
 		hash, err := CalculateSHA1(ctx, input)
 		if err != nil {
-			return "", fmt.Errorf("failed to calculate SHA1: %w", err)
+			return "", nil, fmt.Errorf("failed to calculate SHA1: %w", err)
 		}
 		mediaKey, err := g.FindMediaKeyByHash(ctx, hash)
 		if err != nil {
-			return "", fmt.Errorf("failed to find media in library: %w", err)
+			return "", nil, fmt.Errorf("failed to find media in library: %w", err)
 		}
 		if mediaKey == "" {
-			return "", fmt.Errorf("file not found in Google Photos library")
+			return "", nil, fmt.Errorf("file not found in Google Photos library")
 		}
-		return mediaKey, nil
+		return mediaKey, hash, nil
 	}
 	// Assume it's already a media key
-	return input, nil
+	return input, nil, nil
 }
 
 // IsSupportedByGooglePhotos checks if a file extension is supported by Google Photos
@@ -227,54 +249,3 @@ func IsSupportedByGooglePhotos(filename string) bool {
 	ext = ext[1:]
 	return slices.Contains(GPSupportedPhotoExtensions, ext) || slices.Contains(GPSupportedVideoExtensions, ext)
 }
-
-// GetGooglePhotosSupportedFiles returns files supported by Google Photos from a path
-func GetGooglePhotosSupportedFiles(path string, recursive, disableFilter bool) ([]string, error) {
-	info, err := os.Stat(path)
-	if err != nil {
-		return nil, fmt.Errorf("error accessing %s: %w", path, err)
-	}
-
-	var files []string
-	if info.IsDir() {
-		files, err = scanDir(path, recursive)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		files = []string{path}
-	}
-
-	if disableFilter {
-		return files, nil
-	}
-
-	var result []string
-	for _, f := range files {
-		if IsSupportedByGooglePhotos(f) {
-			result = append(result, f)
-		}
-	}
-	return result, nil
-}
-
-func scanDir(path string, recursive bool) ([]string, error) {
-	entries, err := os.ReadDir(path)
-	if err != nil {
-		return nil, err
-	}
-	var files []string
-	for _, e := range entries {
-		full := filepath.Join(path, e.Name())
-		if e.IsDir() && recursive {
-			sub, err := scanDir(full, true)
-			if err != nil {
-				return nil, err
-			}
-			files = append(files, sub...)
-		} else if !e.IsDir() {
-			files = append(files, full)
-		}
-	}
-	return files, nil
-}
@@ -0,0 +1,197 @@
+package gpm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// WalkOptions controls how a directory is scanned for files
+type WalkOptions struct {
+	Recursive      bool
+	FollowSymlinks bool // Descend into symlinked directories (symlinked files are always included)
+	SkipHidden     bool // Skip files and directories whose name starts with a dot
+	OneFileSystem  bool // Do not descend into directories on other file systems (not supported on Windows)
+}
+
+// WalkFiles lists the regular files below path, or path itself if it is a file.
+// Entries that cannot be read are collected in skipped (as *fs.PathError) instead of
+// stopping the walk; err is only set when path itself is not accessible.
+func WalkFiles(path string, opts WalkOptions) (files []string, skipped []error, err error) {
+	err = WalkFilesFunc(path, opts, func(file string, err error) error {
+		if err != nil {
+			skipped = append(skipped, err)
+		} else {
+			files = append(files, file)
+		}
+		return nil
+	})
+	return files, skipped, err
+}
+
+// WalkFilesFunc streams the regular files below path (or path itself if it is a file)
+// to fn in directory order without collecting them. Entries that cannot be read are
+// passed to fn with a non-nil err and the walk continues; if fn returns an error the
+// walk stops and returns it. Sockets, FIFOs and devices are never listed, and each
+// directory is visited once, so symlink cycles end the walk of that branch.
+func WalkFilesFunc(path string, opts WalkOptions, fn func(path string, err error) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("error accessing %s: %w", path, err)
+	}
+	if !info.IsDir() {
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", path)
+		}
+		return fn(path, nil)
+	}
+
+	w := &walker{opts: opts, fn: fn, visited: make(map[fileKey]bool)}
+	w.root, w.hasRoot = fileKeyOf(info)
+	w.walk(path, info, nil)
+	return w.err
+}
+
+// readDirChunk is the number of directory entries read at a time
+const readDirChunk = 256
+
+// walker holds the state of a WalkFilesFunc call
+type walker struct {
+	opts    WalkOptions
+	fn      func(path string, err error) error
+	err     error // Returned by fn, stops the walk
+	visited map[fileKey]bool
+	root    fileKey
+	hasRoot bool
+}
+
+// file reports a file and returns false once the walk should stop
+func (w *walker) file(path string) bool {
+	w.err = w.fn(path, nil)
+	return w.err == nil
+}
+
+// skip reports an unreadable entry and returns false once the walk should stop
+func (w *walker) skip(err error) bool {
+	path := ""
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		path = pathErr.Path
+	}
+	w.err = w.fn(path, err)
+	return w.err == nil
+}
+
+func (w *walker) walk(dir string, info os.FileInfo, ancestors []os.FileInfo) {
+	// Without file IDs, fall back to detecting cycles against the current branch
+	if key, ok := fileKeyOf(info); ok {
+		if w.visited[key] {
+			return
+		}
+		w.visited[key] = true
+	} else if slices.ContainsFunc(ancestors, func(a os.FileInfo) bool { return os.SameFile(a, info) }) {
+		return
+	}
+	ancestors = append(ancestors, info)
+
+	f, err := os.Open(dir)
+	if err != nil {
+		w.skip(err)
+		return
+	}
+	defer f.Close()
+
+	// Read the listing in chunks, so large directories are never held in memory at once.
+	// ReadDir returns the entries read before an error, keep those.
+	for {
+		entries, err := f.ReadDir(readDirChunk)
+		for _, e := range entries {
+			if w.err != nil || !w.entry(dir, e, ancestors) {
+				return
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				w.skip(err)
+			}
+			return
+		}
+	}
+}
+
+// entry handles one directory entry and returns false once the walk should stop
+func (w *walker) entry(dir string, e fs.DirEntry, ancestors []os.FileInfo) bool {
+	if w.opts.SkipHidden && strings.HasPrefix(e.Name(), ".") {
+		return true
+	}
+	full := filepath.Join(dir, e.Name())
+
+	mode := e.Type()
+	if mode&fs.ModeSymlink != 0 {
+		target, err := os.Stat(full)
+		if err != nil {
+			return w.skip(err)
+		}
+		if target.IsDir() {
+			if w.opts.FollowSymlinks && w.opts.Recursive {
+				w.walkDir(full, target, ancestors)
+			}
+			return true
+		}
+		return !target.Mode().IsRegular() || w.file(full)
+	}
+
+	switch {
+	case mode.IsDir():
+		if !w.opts.Recursive {
+			return true
+		}
+		sub, err := e.Info()
+		if err != nil {
+			return w.skip(err)
+		}
+		w.walkDir(full, sub, ancestors)
+	case mode.IsRegular():
+		return w.file(full)
+	}
+	return true
+}
+
+// walkDir descends into a subdirectory unless it is on another file system
+func (w *walker) walkDir(dir string, info os.FileInfo, ancestors []os.FileInfo) {
+	if w.opts.OneFileSystem && w.hasRoot {
+		if key, ok := fileKeyOf(info); ok && key.dev != w.root.dev {
+			return
+		}
+	}
+	w.walk(dir, info, ancestors)
+}
+
+// FindGooglePhotosFiles returns the files supported by Google Photos from a path.
+// Entries that could not be read are returned in skipped (see WalkFiles).
+func FindGooglePhotosFiles(path string, opts WalkOptions, disableFilter bool) (files []string, skipped []error, err error) {
+	files, skipped, err = WalkFiles(path, opts)
+	if err != nil || disableFilter {
+		return files, skipped, err
+	}
+	return slices.DeleteFunc(files, func(f string) bool { return !IsSupportedByGooglePhotos(f) }), skipped, nil
+}
+
+// GetGooglePhotosSupportedFiles returns files supported by Google Photos from a path.
+// If any entry could not be read, no files are returned with the joined errors; use
+// FindGooglePhotosFiles to keep the files found along with the unreadable entries.
+func GetGooglePhotosSupportedFiles(path string, recursive, disableFilter bool) ([]string, error) {
+	files, skipped, err := FindGooglePhotosFiles(path, WalkOptions{Recursive: recursive}, disableFilter)
+	if err != nil {
+		return nil, err
+	}
+	if len(skipped) > 0 {
+		return nil, errors.Join(skipped...)
+	}
+	return files, nil
+}
@@ -0,0 +1,152 @@
+package gpm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeTree creates empty files below dir
+func writeTree(t *testing.T, dir string, files ...string) {
+	t.Helper()
+	for _, name := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// symlink creates a symlink or skips the test where they are not available
+func symlink(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks not available: %v", err)
+	}
+}
+
+// relativeFiles returns the walked files relative to dir, sorted
+func relativeFiles(t *testing.T, dir string, files []string) []string {
+	t.Helper()
+	rel := make([]string, len(files))
+	for i, file := range files {
+		r, err := filepath.Rel(dir, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel[i] = filepath.ToSlash(r)
+	}
+	slices.Sort(rel)
+	return rel
+}
+
+func TestWalkFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "a.jpg", ".hidden.jpg", "sub/b.png", ".cache/c.jpg")
+
+	tests := []struct {
+		name string
+		opts WalkOptions
+		want []string
+	}{
+		{"top level", WalkOptions{}, []string{".hidden.jpg", "a.jpg"}},
+		{"recursive", WalkOptions{Recursive: true}, []string{".cache/c.jpg", ".hidden.jpg", "a.jpg", "sub/b.png"}},
+		{"skip hidden", WalkOptions{Recursive: true, SkipHidden: true}, []string{"a.jpg", "sub/b.png"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, skipped, err := WalkFiles(dir, tt.opts)
+			if err != nil || len(skipped) > 0 {
+				t.Fatalf("WalkFiles: %v, skipped %v", err, skipped)
+			}
+			if got := relativeFiles(t, dir, files); !slices.Equal(got, tt.want) {
+				t.Errorf("files = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWalkFilesSymlinkLoop(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "a.jpg", "sub/b.jpg")
+	symlink(t, "..", filepath.Join(dir, "sub", "parent"))
+	symlink(t, "sub", filepath.Join(dir, "self"))
+
+	files, skipped, err := WalkFiles(dir, WalkOptions{Recursive: true, FollowSymlinks: true})
+	if err != nil || len(skipped) > 0 {
+		t.Fatalf("WalkFiles: %v, skipped %v", err, skipped)
+	}
+	// Each directory is walked once, through whichever path reaches it first
+	if got := relativeFiles(t, dir, files); len(got) != 2 || got[0] != "a.jpg" {
+		t.Errorf("files = %v, want a.jpg and b.jpg once each", got)
+	}
+
+	files, _, err = WalkFiles(dir, WalkOptions{Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := relativeFiles(t, dir, files); !slices.Equal(got, []string{"a.jpg", "sub/b.jpg"}) {
+		t.Errorf("files without following symlinks = %v, want [a.jpg sub/b.jpg]", got)
+	}
+}
+
+func TestGetGooglePhotosSupportedFilesUnreadable(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "a.jpg", "notes.txt")
+
+	files, err := GetGooglePhotosSupportedFiles(dir, false, false)
+	if err != nil || !slices.Equal(relativeFiles(t, dir, files), []string{"a.jpg"}) {
+		t.Fatalf("GetGooglePhotosSupportedFiles = %v, %v; want [a.jpg]", files, err)
+	}
+
+	// A dangling symlink cannot be read: no files are returned with the error
+	symlink(t, "missing.jpg", filepath.Join(dir, "broken.jpg"))
+	files, err = GetGooglePhotosSupportedFiles(dir, false, false)
+	if err == nil || files != nil {
+		t.Fatalf("GetGooglePhotosSupportedFiles = %v, %v; want no files and an error", files, err)
+	}
+
+	// FindGooglePhotosFiles keeps the files found
+	files, skipped, err := FindGooglePhotosFiles(dir, WalkOptions{}, false)
+	if err != nil || len(skipped) != 1 || len(files) != 1 {
+		t.Fatalf("FindGooglePhotosFiles = %v, %v, %v; want [a.jpg] and one skipped entry", files, skipped, err)
+	}
+}
+
+func TestWalkFilesFuncLargeDirectory(t *testing.T) {
+	dir := t.TempDir()
+	n := 2*readDirChunk + 10
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("%04d.jpg", i)
+	}
+	writeTree(t, dir, append(names, "sub/last.jpg")...)
+
+	// Every chunk of the listing is walked, and subdirectories in between
+	files, skipped, err := WalkFiles(dir, WalkOptions{Recursive: true})
+	if err != nil || len(skipped) > 0 {
+		t.Fatalf("WalkFiles: %v, skipped %v", err, skipped)
+	}
+	if got := relativeFiles(t, dir, files); len(got) != n+1 || !slices.Equal(got[:n], names) {
+		t.Fatalf("walked %d files, want %d", len(got), n+1)
+	}
+
+	// An error from fn stops the walk within a chunk
+	stop := errors.New("stop")
+	var seen int
+	err = WalkFilesFunc(dir, WalkOptions{Recursive: true}, func(string, error) error {
+		if seen++; seen == readDirChunk+5 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || seen != readDirChunk+5 {
+		t.Fatalf("WalkFilesFunc = %v after %d files, want stop after %d", err, seen, readDirChunk+5)
+	}
+}